@@ -0,0 +1,51 @@
+package pageant
+
+import (
+	"strings"
+)
+
+// normalizePipePath returns the named pipe path of value, an agent path as
+// users set SSH_AUTH_SOCK on Windows, prepending prefix to bare pipe names.
+// It reports filesystem paths, which name unix sockets, with socket.
+func normalizePipePath(value, prefix string) (path string, socket bool) {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	npipe := false
+	if len(value) >= 6 && strings.EqualFold(value[:6], "npipe:") {
+		value = value[6:]
+		npipe = true
+	}
+
+	path = strings.ReplaceAll(value, "/", `\`)
+	if npipe {
+		// npipe:////./pipe/name and npipe://./pipe/name alike
+		path = strings.TrimLeft(path, `\`)
+		if isPipePath(`\\` + path) {
+			return `\\` + path, false
+		}
+		return prefix + path, false
+	}
+	if isPipePath(path) {
+		return path, false
+	}
+	if strings.ContainsAny(value, `/\`) || (len(value) >= 2 && value[1] == ':') {
+		return value, true
+	}
+	return prefix + value, false
+}
+
+// isPipePath tells whether path is \\HOST\pipe\NAME, HOST being . for the
+// local machine.
+func isPipePath(path string) bool {
+	if !strings.HasPrefix(path, `\\`) {
+		return false
+	}
+	host, rest, ok := strings.Cut(path[2:], `\`)
+	if !ok || host == "" || host == "?" {
+		return false
+	}
+	pipe, name, ok := strings.Cut(rest, `\`)
+	return ok && strings.EqualFold(pipe, "pipe") && name != ""
+}
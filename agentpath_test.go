@@ -0,0 +1,34 @@
+package pageant
+
+import "testing"
+
+func TestNormalizePipePath(t *testing.T) {
+	const prefix = `\\.\pipe\`
+	for _, test := range []struct {
+		value  string
+		path   string
+		socket bool
+	}{
+		{`\\.\pipe\openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{`\\.\PIPE\openssh-ssh-agent`, `\\.\PIPE\openssh-ssh-agent`, false},
+		{`//./pipe/openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{`\\server\pipe\agent`, `\\server\pipe\agent`, false},
+		{`openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{` "openssh-ssh-agent" `, `\\.\pipe\openssh-ssh-agent`, false},
+		{`npipe:openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{`npipe:////./pipe/openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{`npipe://./pipe/openssh-ssh-agent`, `\\.\pipe\openssh-ssh-agent`, false},
+		{`C:\Users\me\.ssh\agent.sock`, `C:\Users\me\.ssh\agent.sock`, true},
+		{`\\?\C:\agent.sock`, `\\?\C:\agent.sock`, true},
+		{`/tmp/ssh-XXXX/agent.1234`, `/tmp/ssh-XXXX/agent.1234`, true},
+		{`.ssh\agent.sock`, `.ssh\agent.sock`, true},
+	} {
+		path, socket := normalizePipePath(test.value, prefix)
+		if path != test.path || socket != test.socket {
+			t.Errorf("normalizePipePath(%q) = %q, %v, want %q, %v", test.value, path, socket, test.path, test.socket)
+		}
+	}
+	if path, _ := normalizePipePath("agent", (&Options{PipePrefix: `\\server\pipe`}).pipePrefix()); path != `\\server\pipe\agent` {
+		t.Errorf("normalizePipePath with a custom prefix = %q", path)
+	}
+}
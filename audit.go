@@ -0,0 +1,274 @@
+package pageant
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuditRecord is one line of the key usage audit trail of an AuditAgent.
+// Each record carries the MAC of its predecessor, so that modifying,
+// removing or reordering records breaks the chain checked by VerifyAuditLog.
+type AuditRecord struct {
+	Seq         uint64    `json:"seq"`
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Prev        string    `json:"prev"`
+	MAC         string    `json:"mac"`
+}
+
+// mac returns the hex HMAC-SHA256 of r without its MAC field, or its plain
+// SHA-256 if key is empty.
+func (r AuditRecord) mac(key []byte) (string, error) {
+	r.MAC = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AuditAgent forwards to another agent and writes an AuditRecord for each
+// call as a line of JSON.
+type AuditAgent struct {
+	agent agent.ExtendedAgent
+	key   []byte
+	mu    sync.Mutex
+	w     io.Writer
+	file  *auditFile
+	seq   uint64
+	prev  string
+}
+
+// auditFile is an audit log file shared by processes, each locking it to
+// append its records after the last one written by any of them.
+type auditFile struct {
+	*os.File
+	// offset is the end of the records read or written by this process.
+	offset int64
+}
+
+// NewAuditAgent returns an AuditAgent logging the calls to a into w.
+// Records are chained with HMAC-SHA256 under key; without a key the chain
+// of plain SHA-256 only detects accidental damage, not a forger.
+func NewAuditAgent(a agent.ExtendedAgent, w io.Writer, key []byte) *AuditAgent {
+	return &AuditAgent{agent: a, key: key, w: w}
+}
+
+// ResumeAuditAgent is NewAuditAgent appending to a log whose last record is
+// last, as returned by VerifyAuditLog, continuing its chain. last is nil
+// for an empty log.
+func ResumeAuditAgent(a agent.ExtendedAgent, w io.Writer, key []byte, last *AuditRecord) *AuditAgent {
+	audit := NewAuditAgent(a, w, key)
+	if last != nil {
+		audit.seq = last.Seq
+		audit.prev = last.MAC
+	}
+	return audit
+}
+
+// Last returns the sequence number and MAC of the last record written.
+// Keeping them outside of the log allows VerifyAuditLog to detect that the
+// end of the log was truncated.
+func (a *AuditAgent) Last() (uint64, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seq, a.prev
+}
+
+// log appends a record for op on key. Failing to write the audit trail
+// fails the call, unless the call itself failed.
+func (a *AuditAgent) log(op string, key ssh.PublicKey, comment string, err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if errLock := lockFile(a.file.File); errLock != nil {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to lock audit log: %s", errLock)
+		}
+		defer unlockFile(a.file.File)
+		if errSync := a.catchUp(); errSync != nil {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to write audit log: %s", errSync)
+		}
+	}
+
+	record := AuditRecord{
+		Seq:     a.seq + 1,
+		Time:    time.Now().UTC(),
+		Op:      op,
+		Comment: comment,
+		Prev:    a.prev,
+	}
+	if key != nil {
+		record.Fingerprint = ssh.FingerprintSHA256(key)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	mac, errLog := record.mac(a.key)
+	if errLog == nil {
+		record.MAC = mac
+		var line []byte
+		if line, errLog = json.Marshal(record); errLog == nil {
+			var n int
+			n, errLog = a.w.Write(append(line, '\n'))
+			if a.file != nil {
+				a.file.offset += int64(n)
+			}
+		}
+	}
+	if errLog != nil {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to write audit log: %s", errLog)
+	}
+	a.seq = record.Seq
+	a.prev = record.MAC
+	return err
+}
+
+func (a *AuditAgent) List() ([]*agent.Key, error) {
+	keys, err := a.agent.List()
+	if err := a.log("list", nil, "", err); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (a *AuditAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	sig, err := a.agent.Sign(key, data)
+	if err := a.log("sign", key, "", err); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (a *AuditAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	sig, err := a.agent.SignWithFlags(key, data, flags)
+	if err := a.log("sign", key, "", err); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (a *AuditAgent) Add(key agent.AddedKey) error {
+	var pub ssh.PublicKey
+	if key.Certificate != nil {
+		pub = key.Certificate
+	} else if signer, err := ssh.NewSignerFromKey(key.PrivateKey); err == nil {
+		pub = signer.PublicKey()
+	}
+	return a.log("add", pub, key.Comment, a.agent.Add(key))
+}
+
+func (a *AuditAgent) Remove(key ssh.PublicKey) error {
+	return a.log("remove", key, "", a.agent.Remove(key))
+}
+
+func (a *AuditAgent) RemoveAll() error {
+	return a.log("remove-all", nil, "", a.agent.RemoveAll())
+}
+
+func (a *AuditAgent) Lock(passphrase []byte) error {
+	return a.log("lock", nil, "", a.agent.Lock(passphrase))
+}
+
+func (a *AuditAgent) Unlock(passphrase []byte) error {
+	return a.log("unlock", nil, "", a.agent.Unlock(passphrase))
+}
+
+func (a *AuditAgent) Signers() ([]ssh.Signer, error) {
+	return agentSigners(a)
+}
+
+func (a *AuditAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	response, err := a.agent.Extension(extensionType, contents)
+	if err := a.log("extension "+extensionType, nil, "", err); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// catchUp continues the chain after the records other processes appended
+// to the file of a since it last read or wrote it. The caller must hold the
+// lock of a and of its file.
+func (a *AuditAgent) catchUp() error {
+	info, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	var last *AuditRecord
+	if a.seq > 0 {
+		last = &AuditRecord{Seq: a.seq, MAC: a.prev}
+	}
+	r := io.NewSectionReader(a.file, a.file.offset, info.Size()-a.file.offset)
+	if last, err = verifyAuditRecords(r, a.key, last); err != nil {
+		return err
+	}
+	if last != nil {
+		a.seq = last.Seq
+		a.prev = last.MAC
+	}
+	a.file.offset = info.Size()
+	return nil
+}
+
+// VerifyAuditLog checks the chain of the records read from r, written by an
+// AuditAgent with key, and returns the last one. An error names the first
+// record that was modified, removed or reordered, the chain starting with
+// record 1. Comparing the result with AuditAgent.Last also detects a
+// truncated log.
+func VerifyAuditLog(r io.Reader, key []byte) (*AuditRecord, error) {
+	return verifyAuditRecords(r, key, nil)
+}
+
+// verifyAuditRecords is VerifyAuditLog for the records following last, or
+// starting the chain if last is nil, and returns last if there are none.
+func verifyAuditRecords(r io.Reader, key []byte, last *AuditRecord) (*AuditRecord, error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return last, fmt.Errorf("audit log line %d is not a record: %s", line, err)
+		}
+		mac, err := record.mac(key)
+		if err != nil {
+			return last, err
+		}
+		if !hmac.Equal([]byte(mac), []byte(record.MAC)) {
+			return last, fmt.Errorf("audit log line %d was modified", line)
+		}
+		if last == nil && (record.Seq != 1 || record.Prev != "") {
+			return last, fmt.Errorf("audit log line %d does not start the chain", line)
+		} else if last != nil && (record.Seq != last.Seq+1 || record.Prev != last.MAC) {
+			return last, fmt.Errorf("audit log line %d does not follow record %d", line, last.Seq)
+		}
+		last = record
+	}
+	return last, scanner.Err()
+}
@@ -0,0 +1,74 @@
+package pageant
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAuditLog(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on GenerateKey: %s", err)
+	}
+	key := []byte("audit key")
+	var log bytes.Buffer
+	audit := NewAuditAgent(agent.NewKeyring().(agent.ExtendedAgent), &log, key)
+	if err := audit.Add(agent.AddedKey{PrivateKey: priv, Comment: "test"}); err != nil {
+		t.Fatalf("error on Add: %s", err)
+	}
+	signers, err := audit.Signers()
+	if err != nil {
+		t.Fatalf("error on Signers: %s", err)
+	}
+	if _, err := signers[0].Sign(rand.Reader, []byte("data")); err != nil {
+		t.Fatalf("error on Sign: %s", err)
+	}
+
+	last, err := VerifyAuditLog(bytes.NewReader(log.Bytes()), key)
+	if err != nil {
+		t.Fatalf("error on VerifyAuditLog: %s", err)
+	}
+	if seq, mac := audit.Last(); last.Seq != seq || last.MAC != mac || last.Op != "sign" {
+		t.Fatalf("last record %+v does not match %d %s", last, seq, mac)
+	}
+
+	lines := strings.SplitAfter(log.String(), "\n")
+	tampered := strings.Replace(log.String(), `"op":"list"`, `"op":"lock"`, 1)
+	removed := lines[0] + lines[2]
+	cut := lines[1] + lines[2]
+	for name, content := range map[string]string{"tampered": tampered, "removed": removed, "cut": cut} {
+		if _, err := VerifyAuditLog(strings.NewReader(content), key); err == nil {
+			t.Fatalf("VerifyAuditLog accepted %s log", name)
+		}
+	}
+	if _, err := VerifyAuditLog(bytes.NewReader(log.Bytes()), []byte("other key")); err == nil {
+		t.Fatalf("VerifyAuditLog accepted a log with the wrong key")
+	}
+}
+
+func TestAuditLogResume(t *testing.T) {
+	key := []byte("audit key")
+	var log bytes.Buffer
+	for run := 0; run < 2; run++ {
+		last, err := VerifyAuditLog(bytes.NewReader(log.Bytes()), key)
+		if err != nil {
+			t.Fatalf("error on VerifyAuditLog of run %d: %s", run, err)
+		}
+		audit := ResumeAuditAgent(agent.NewKeyring().(agent.ExtendedAgent), &log, key, last)
+		if _, err := audit.List(); err != nil {
+			t.Fatalf("error on List: %s", err)
+		}
+	}
+	last, err := VerifyAuditLog(bytes.NewReader(log.Bytes()), key)
+	if err != nil {
+		t.Fatalf("error on VerifyAuditLog of a resumed log: %s", err)
+	}
+	if last.Seq != 2 {
+		t.Fatalf("resumed log ends with record %d rather than 2", last.Seq)
+	}
+}
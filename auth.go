@@ -0,0 +1,60 @@
+package pageant
+
+import (
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Selector picks the keys, in order of preference, to authenticate to host
+// with among keys, such as the work key for *.corp and the personal key
+// elsewhere.
+type Selector func(host string, keys []*agent.Key) []*agent.Key
+
+// Auth authenticates SSH clients with the keys of an agent.
+type Auth struct {
+	// Agent holds the keys, such as agent.NewClient on the connection
+	// returned by NewConn.
+	Agent agent.ExtendedAgent
+
+	// Selector picks the keys offered to each host; all the keys of Agent
+	// are offered if it is nil.
+	Selector Selector
+}
+
+// AuthMethod returns an ssh.AuthMethod offering the keys Selector picks
+// for host, listed when the server asks for them. host may have a port.
+func (a *Auth) AuthMethod(host string) ssh.AuthMethod {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		keys, err := a.Agent.List()
+		if err != nil {
+			return nil, err
+		}
+		if a.Selector != nil {
+			keys = a.Selector(host, keys)
+		}
+		signers := make([]ssh.Signer, 0, len(keys))
+		for _, key := range keys {
+			pub, err := ssh.ParsePublicKey(key.Blob)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, &agentSigner{agent: a.Agent, pub: pub})
+		}
+		return signers, nil
+	})
+}
+
+// ClientConfig returns a configuration for ssh.Dial to log in as user on
+// host with AuthMethod, checking the key of host with hostKeyCallback.
+func (a *Auth) ClientConfig(user, host string, hostKeyCallback ssh.HostKeyCallback) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{a.AuthMethod(host)},
+		HostKeyCallback: hostKeyCallback,
+	}
+}
@@ -0,0 +1,115 @@
+package pageant
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshLogin logs in to an SSH server over loopback with config, accepting
+// only the key of accept, and returns the keys the client offered.
+func sshLogin(t *testing.T, config *ssh.ClientConfig, accept ssh.PublicKey) ([]ssh.PublicKey, error) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on GenerateKey: %s", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("error on NewSignerFromKey: %s", err)
+	}
+	var offered []ssh.PublicKey
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			offered = append(offered, key)
+			if bytes.Equal(key.Marshal(), accept.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("key not accepted")
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	// both sides write their version at once, which net.Pipe cannot take
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		if conn, _, _, err := ssh.NewServerConn(server, serverConfig); err == nil {
+			conn.Close()
+		}
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("error on Dial: %s", err)
+	}
+	conn, _, _, err := ssh.NewClientConn(client, "work.corp:22", config)
+	if err == nil {
+		conn.Close()
+	}
+	client.Close()
+	<-done
+	return offered, err
+}
+
+func TestAuthSelector(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	var pubs []ssh.PublicKey
+	for _, comment := range []string{"personal", "work"} {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on GenerateKey: %s", err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: comment}); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+		sshPub, _ := ssh.NewPublicKey(pub)
+		pubs = append(pubs, sshPub)
+	}
+
+	var selectedHost string
+	auth := &Auth{
+		Agent: keyring,
+		Selector: func(host string, keys []*agent.Key) []*agent.Key {
+			selectedHost = host
+			var selected []*agent.Key
+			for _, key := range keys {
+				if (key.Comment == "work") == strings.HasSuffix(host, ".corp") {
+					selected = append(selected, key)
+				}
+			}
+			return selected
+		},
+	}
+	config := auth.ClientConfig("me", "work.corp:22", ssh.InsecureIgnoreHostKey())
+	offered, err := sshLogin(t, config, pubs[1])
+	if err != nil {
+		t.Fatalf("error on login: %s", err)
+	}
+	if selectedHost != "work.corp" {
+		t.Fatalf("Selector got host %q", selectedHost)
+	}
+	if len(offered) != 1 || !bytes.Equal(offered[0].Marshal(), pubs[1].Marshal()) {
+		t.Fatalf("client offered %d keys rather than the work key only", len(offered))
+	}
+
+	config = auth.ClientConfig("me", "home.example", ssh.InsecureIgnoreHostKey())
+	if _, err := sshLogin(t, config, pubs[1]); err == nil {
+		t.Fatalf("login succeeded with the work key outside .corp")
+	}
+}
@@ -0,0 +1,225 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	wintrust                             = windows.NewLazySystemDLL("wintrust.dll")
+	wtHelperProvDataFromStateData        = wintrust.NewProc("WTHelperProvDataFromStateData")
+	wtHelperGetProvSignerFromChain       = wintrust.NewProc("WTHelperGetProvSignerFromChain")
+	wtHelperGetProvCertFromChain         = wintrust.NewProc("WTHelperGetProvCertFromChain")
+	cryptCATAdminAcquireContext2         = wintrust.NewProc("CryptCATAdminAcquireContext2")
+	cryptCATAdminReleaseContext          = wintrust.NewProc("CryptCATAdminReleaseContext")
+	cryptCATAdminCalcHashFromFileHandle2 = wintrust.NewProc("CryptCATAdminCalcHashFromFileHandle2")
+	cryptCATAdminEnumCatalogFromHash     = wintrust.NewProc("CryptCATAdminEnumCatalogFromHash")
+	cryptCATAdminReleaseCatalogContext   = wintrust.NewProc("CryptCATAdminReleaseCatalogContext")
+	cryptCATCatalogInfoFromContext       = wintrust.NewProc("CryptCATCatalogInfoFromContext")
+)
+
+// windowProcessID returns the id of the process owning window.
+func windowProcessID(window uintptr) (uint32, error) {
+	var pid uint32
+	if _, err := windows.GetWindowThreadProcessId(windows.HWND(window), &pid); err != nil {
+		return 0, fmt.Errorf("cannot get Pageant process: %s", err)
+	}
+	return pid, nil
+}
+
+// processImagePath returns the full path of the executable of process pid.
+func processImagePath(pid uint32) (string, error) {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", fmt.Errorf("cannot open process %d: %s", pid, err)
+	}
+	defer windows.CloseHandle(process)
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(process, 0, &buf[0], &size); err != nil {
+		return "", fmt.Errorf("cannot get executable of process %d: %s", pid, err)
+	}
+	return windows.UTF16ToString(buf[:size]), nil
+}
+
+// verifyPublisher checks that the executable of process pid is signed by
+// one of publishers.
+func verifyPublisher(pid uint32, publishers []string) error {
+	path, err := processImagePath(pid)
+	if err != nil {
+		return err
+	}
+	signer, err := fileSigner(path)
+	if err != nil {
+		return fmt.Errorf("cannot verify signature of %s: %s", path, err)
+	}
+	for _, publisher := range publishers {
+		if strings.EqualFold(signer, publisher) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is signed by %q which is not an allowed publisher", path, signer)
+}
+
+// fileSigner verifies the Authenticode signature of path, embedded or
+// through a system catalog, and returns the name of the signer.
+func fileSigner(path string) (string, error) {
+	pathUTF16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	fileInfo := windows.WinTrustFileInfo{
+		Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+		FilePath: pathUTF16,
+	}
+	signer, err := winVerifyTrust(windows.WTD_CHOICE_FILE, unsafe.Pointer(&fileInfo))
+	if err == syscall.Errno(windows.TRUST_E_NOSIGNATURE) {
+		return catalogSigner(path, pathUTF16)
+	}
+	return signer, err
+}
+
+// catalogSigner verifies path against the system catalogs, which is how the
+// binaries shipped with Windows, such as the inbox ssh-agent.exe, are signed.
+func catalogSigner(path string, pathUTF16 *uint16) (string, error) {
+	file, err := windows.CreateFile(pathUTF16, windows.GENERIC_READ, windows.FILE_SHARE_READ,
+		nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(file)
+
+	var admin windows.Handle
+	result, _, err := cryptCATAdminAcquireContext2.Call(
+		uintptr(unsafe.Pointer(&admin)),
+		0,
+		uintptr(unsafe.Pointer(utf16Ptr("SHA256"))),
+		0,
+		0,
+	)
+	if result == 0 {
+		return "", err
+	}
+	defer cryptCATAdminReleaseContext.Call(uintptr(admin), 0)
+
+	hash := make([]byte, 64)
+	hashSize := uint32(len(hash))
+	result, _, err = cryptCATAdminCalcHashFromFileHandle2.Call(
+		uintptr(admin),
+		uintptr(file),
+		uintptr(unsafe.Pointer(&hashSize)),
+		uintptr(unsafe.Pointer(&hash[0])),
+		0,
+	)
+	if result == 0 {
+		return "", err
+	}
+	catalog, _, _ := cryptCATAdminEnumCatalogFromHash.Call(
+		uintptr(admin),
+		uintptr(unsafe.Pointer(&hash[0])),
+		uintptr(hashSize),
+		0,
+		0,
+	)
+	if catalog == 0 {
+		return "", syscall.Errno(windows.TRUST_E_NOSIGNATURE)
+	}
+	defer cryptCATAdminReleaseCatalogContext.Call(uintptr(admin), catalog, 0)
+
+	info := catalogInfo{size: uint32(unsafe.Sizeof(catalogInfo{}))}
+	result, _, err = cryptCATCatalogInfoFromContext.Call(catalog, uintptr(unsafe.Pointer(&info)), 0)
+	if result == 0 {
+		return "", err
+	}
+	memberTag, err := windows.UTF16PtrFromString(strings.ToUpper(hex.EncodeToString(hash[:hashSize])))
+	if err != nil {
+		return "", err
+	}
+	trustInfo := wintrustCatalogInfo{
+		size:               uint32(unsafe.Sizeof(wintrustCatalogInfo{})),
+		catalogFilePath:    &info.catalogFile[0],
+		memberTag:          memberTag,
+		memberFilePath:     pathUTF16,
+		memberFile:         file,
+		calculatedHash:     &hash[0],
+		calculatedHashSize: hashSize,
+		catAdmin:           admin,
+	}
+	return winVerifyTrust(windows.WTD_CHOICE_CATALOG, unsafe.Pointer(&trustInfo))
+}
+
+// winVerifyTrust runs WinVerifyTrust on subject and returns the simple
+// display name of the leaf certificate of the first signer.
+func winVerifyTrust(choice uint32, subject unsafe.Pointer) (string, error) {
+	data := windows.WinTrustData{
+		Size:                            uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:                        windows.WTD_UI_NONE,
+		RevocationChecks:                windows.WTD_REVOKE_NONE,
+		UnionChoice:                     choice,
+		FileOrCatalogOrBlobOrSgnrOrCert: subject,
+		StateAction:                     windows.WTD_STATEACTION_VERIFY,
+		ProvFlags:                       windows.WTD_CACHE_ONLY_URL_RETRIEVAL,
+	}
+	err := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, &data)
+	defer func() {
+		data.StateAction = windows.WTD_STATEACTION_CLOSE
+		windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, &data)
+	}()
+	if err != nil {
+		return "", err
+	}
+
+	provData, _, _ := wtHelperProvDataFromStateData.Call(uintptr(data.StateData))
+	if provData == 0 {
+		return "", fmt.Errorf("no provider data")
+	}
+	signer, _, _ := wtHelperGetProvSignerFromChain.Call(provData, 0, 0, 0)
+	if signer == 0 {
+		return "", fmt.Errorf("no signer")
+	}
+	provCert, _, _ := wtHelperGetProvCertFromChain.Call(signer, 0)
+	if provCert == 0 {
+		return "", fmt.Errorf("no signer certificate")
+	}
+	cert := (*(**cryptProviderCert)(unsafe.Pointer(&provCert))).cert
+	name := make([]uint16, 256)
+	size := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], uint32(len(name)))
+	if size <= 1 {
+		return "", fmt.Errorf("signer certificate has no name")
+	}
+	return windows.UTF16ToString(name[:size]), nil
+}
+
+// catalogInfo is equivalent to CATALOG_INFO.
+type catalogInfo struct {
+	size        uint32
+	catalogFile [windows.MAX_PATH]uint16
+}
+
+// wintrustCatalogInfo is equivalent to WINTRUST_CATALOG_INFO.
+type wintrustCatalogInfo struct {
+	size               uint32
+	catalogVersion     uint32
+	catalogFilePath    *uint16
+	memberTag          *uint16
+	memberFilePath     *uint16
+	memberFile         windows.Handle
+	calculatedHash     *byte
+	calculatedHashSize uint32
+	catalogContext     uintptr
+	catAdmin           windows.Handle
+}
+
+// cryptProviderCert is the leading part of CRYPT_PROVIDER_CERT.
+type cryptProviderCert struct {
+	size uint32
+	cert *windows.CertContext
+}
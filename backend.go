@@ -0,0 +1,208 @@
+package pageant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Backend names, as accepted by Available.
+const (
+	// BackendPageant is PuTTY's Pageant, reached through its window.
+	BackendPageant = "pageant"
+	// BackendPipe is a named pipe agent such as ssh-agent.exe of OpenSSH
+	// for Windows.
+	BackendPipe = "pipe"
+	// BackendUnix is a unix socket agent such as ssh-agent.
+	BackendUnix = "unix"
+)
+
+// availableTimeout bounds each Available probe.
+const availableTimeout = 200 * time.Millisecond
+
+// Available tells whether the agent of backend, one of the Backend names,
+// accepts connections. It never waits for longer than a fraction of a
+// second, so that it can be polled to show the status of an agent.
+func Available(backend string) bool {
+	switch backend {
+	case BackendPageant:
+		return PageantAvailable()
+	case BackendPipe:
+		return pipeAvailable(availableTimeout)
+	case BackendUnix:
+		return unixAvailable(availableTimeout)
+	}
+	return false
+}
+
+// availableTTL is how long IsPageantAvailable, IsOpenSSHAgentAvailable and
+// IsUnixAgentAvailable reuse the answer of a probe.
+const availableTTL = time.Second
+
+var availableCache struct {
+	mu      sync.Mutex
+	results map[string]availableResult
+}
+
+type availableResult struct {
+	available bool
+	probed    time.Time
+}
+
+// IsPageantAvailable tells whether Pageant is running, probing it at most
+// once a second, so that GUIs can poll it to show its status.
+func IsPageantAvailable() bool {
+	return cachedAvailable(BackendPageant)
+}
+
+// IsOpenSSHAgentAvailable is IsPageantAvailable for the named pipe agent
+// of OpenSSH for Windows.
+func IsOpenSSHAgentAvailable() bool {
+	return cachedAvailable(BackendPipe)
+}
+
+// IsUnixAgentAvailable is IsPageantAvailable for the unix socket agent of
+// SSH_AUTH_SOCK.
+func IsUnixAgentAvailable() bool {
+	return cachedAvailable(BackendUnix)
+}
+
+// cachedAvailable returns Available for backend, probed at most once per
+// availableTTL.
+func cachedAvailable(backend string) bool {
+	availableCache.mu.Lock()
+	result, ok := availableCache.results[backend]
+	availableCache.mu.Unlock()
+	if ok && time.Since(result.probed) < availableTTL {
+		return result.available
+	}
+
+	result = availableResult{available: Available(backend), probed: time.Now()}
+	availableCache.mu.Lock()
+	if availableCache.results == nil {
+		availableCache.results = make(map[string]availableResult)
+	}
+	availableCache.results[backend] = result
+	availableCache.mu.Unlock()
+	return result.available
+}
+
+// NewConnWithOptions is like NewConn but tuned by opts.
+// It connects to the first agent found among Options.Backends.
+func NewConnWithOptions(opts *Options) (net.Conn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	backends := opts.backends()
+	if len(backends) == 0 {
+		if err := systemConfig().err; err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no agent backend allowed by policy")
+	}
+	var err error
+	for _, backend := range backends {
+		if backend == BackendPageant && !PageantAvailable() {
+			_, err = PageantWindow()
+			continue
+		}
+		var conn net.Conn
+		conn, err = dialBackend(backend, opts)
+		// an agent refused by policy must not hide the next one
+		var refused *policyError
+		if err == nil || (backend == BackendPageant && !errors.As(err, &refused)) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
+// backends returns Options.Backends, Config.Backends or the default order
+// of the platform, leaving out the backends Config.AllowedBackends forbids.
+func (o *Options) backends() []string {
+	config := systemConfig()
+	backends := o.Backends
+	if len(backends) == 0 {
+		backends = config.Backends
+	}
+	if len(backends) == 0 {
+		if runtime.GOOS == "windows" {
+			backends = []string{BackendPageant, BackendPipe}
+		} else {
+			backends = []string{BackendUnix}
+		}
+	}
+	allowed := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		if config.permits(backend) {
+			allowed = append(allowed, backend)
+		}
+	}
+	return allowed
+}
+
+// dialBackend connects to the agent of backend only, rather than to the
+// first agent found as NewConnWithOptions does.
+func dialBackend(backend string, opts *Options) (net.Conn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if config := systemConfig(); config.err != nil {
+		return nil, &policyError{config.err}
+	} else if !config.permits(backend) {
+		return nil, &policyError{fmt.Errorf("agent backend %q is forbidden by policy", backend)}
+	}
+	switch backend {
+	case BackendPageant:
+		return dialPageant(opts)
+	case BackendPipe:
+		return dialNamedPipe(opts)
+	case BackendUnix:
+		return dialUnix(opts)
+	}
+	return nil, fmt.Errorf("unknown agent backend %q", backend)
+}
+
+// dialUnix connects to the unix socket agent in Options.AgentPath or
+// SSH_AUTH_SOCK.
+func dialUnix(opts *Options) (net.Conn, error) {
+	const sshAuthSock = "SSH_AUTH_SOCK"
+	socket := opts.AgentPath
+	if socket == "" {
+		socket = os.Getenv(sshAuthSock)
+	}
+	if socket == "" {
+		return nil, fmt.Errorf("empty %s", sshAuthSock)
+	}
+	return dialSocket(socket)
+}
+
+// dialSocket connects to the unix socket agent at path, verifying its owner
+// if Config.RequireOwnAgent is set.
+func dialSocket(path string) (net.Conn, error) {
+	if systemConfig().RequireOwnAgent {
+		if err := verifySocketOwner(path); err != nil {
+			return nil, &policyError{err}
+		}
+	}
+	return net.Dial("unix", path)
+}
+
+// unixAvailable tells whether the unix socket in SSH_AUTH_SOCK accepts
+// connections within timeout.
+func unixAvailable(timeout time.Duration) bool {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,46 @@
+package pageant
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAvailableDeadEndpoint(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	start := time.Now()
+	for _, backend := range []string{BackendUnix, "unknown"} {
+		if Available(backend) {
+			t.Fatalf("Available(%q) reported a dead endpoint", backend)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Available took %s on dead endpoints", elapsed)
+	}
+}
+
+func TestIsUnixAgentAvailable(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	availableCache.mu.Lock()
+	delete(availableCache.results, BackendUnix)
+	availableCache.mu.Unlock()
+	if IsUnixAgentAvailable() {
+		t.Fatalf("IsUnixAgentAvailable reported a dead endpoint")
+	}
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	if IsUnixAgentAvailable() {
+		t.Fatalf("IsUnixAgentAvailable probed again within %s", availableTTL)
+	}
+
+	availableCache.mu.Lock()
+	delete(availableCache.results, BackendUnix)
+	availableCache.mu.Unlock()
+	if !IsUnixAgentAvailable() {
+		t.Fatalf("IsUnixAgentAvailable did not report a listening agent")
+	}
+}
@@ -0,0 +1,135 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// agentMaxMsglen is the AGENT_MAX_MSGLEN of Pageant before 0.75.
+	agentMaxMsglen = 8192
+	// pageantLargeMsglen is the AGENT_MAX_MSGLEN of Pageant 0.75 and later,
+	// which answer the query extension and size requests and responses by
+	// the shared memory of the client rather than by agentMaxMsglen.
+	pageantLargeMsglen = 256 * 1024
+	agentSuccess       = 6
+	agentcExtension    = 27
+)
+
+// AgentCapabilities is what the agent of a connection supports, as found
+// by Capabilities.
+type AgentCapabilities struct {
+	// Backend is the Backend name of the connection.
+	Backend string `json:"backend"`
+	// Query tells whether the agent answers the query extension, listing
+	// its Extensions.
+	Query      bool     `json:"query"`
+	Extensions []string `json:"extensions,omitempty"`
+	// Constraints tells whether keys can be added with a lifetime, which
+	// Pageant refuses.
+	Constraints bool `json:"constraints"`
+	// Lock tells whether the agent can be locked, which Pageant cannot.
+	Lock bool `json:"lock"`
+	// MaxMessageSize is the longest request or response of the agent.
+	MaxMessageSize int `json:"max_message_size"`
+}
+
+// Capabilities finds out what the agent connected by conn supports, asking
+// it with the query extension, so that callers can adapt their features.
+// Constraints and Lock are probed: a throwaway key is added with a lifetime
+// of a second and removed, and the agent is locked with a random passphrase
+// and unlocked at once, which other clients of the agent may briefly see.
+// conn stays usable for other requests.
+func Capabilities(conn net.Conn) (*AgentCapabilities, error) {
+	client := agent.NewClient(conn)
+	caps := &AgentCapabilities{Backend: BackendPipe}
+	if isPageantConn(conn) {
+		caps.Backend = BackendPageant
+	} else if conn.RemoteAddr() != nil && conn.RemoteAddr().Network() == "unix" {
+		caps.Backend = BackendUnix
+	}
+
+	response, err := client.Extension("query", nil)
+	if err == nil && len(response) > 0 && response[0] == agentSuccess {
+		caps.Query = true
+		caps.Extensions = parseStrings(response[1:])
+	} else if _, err := client.List(); err != nil {
+		// refusing the extension is fine, but not a broken connection
+		return nil, err
+	}
+
+	if caps.Constraints, err = probeConstraints(client); err != nil {
+		return nil, err
+	}
+	if caps.Lock, err = probeLock(client); err != nil {
+		return nil, err
+	}
+
+	if caps.Backend == BackendPageant {
+		// Pageant before 0.75 has no query extension to tell its own
+		if caps.MaxMessageSize, err = pageantConnMsglen(conn); err != nil {
+			return nil, err
+		}
+	} else {
+		caps.MaxMessageSize = pipelineMaxMsglen
+	}
+	return caps, nil
+}
+
+// probeConstraints tells whether client adds a key with a lifetime. The key
+// is a fresh one, removed again, and expires by itself should that fail.
+func probeConstraints(client agent.ExtendedAgent) (bool, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return false, fmt.Errorf("generate probe key failed: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return false, fmt.Errorf("generate probe key failed: %s", err)
+	}
+	if err := client.Add(agent.AddedKey{PrivateKey: key, Comment: "pageant capabilities probe", LifetimeSecs: 1}); err != nil {
+		return false, nil
+	}
+	if err := client.Remove(signer.PublicKey()); err != nil {
+		return true, fmt.Errorf("remove probe key failed: %s", err)
+	}
+	return true, nil
+}
+
+// probeLock tells whether client can be locked, unlocking it at once. An
+// agent that is locked already refuses to be locked again.
+func probeLock(client agent.ExtendedAgent) (bool, error) {
+	passphrase := make([]byte, 32)
+	if _, err := rand.Read(passphrase); err != nil {
+		return false, fmt.Errorf("generate probe passphrase failed: %s", err)
+	}
+	if err := client.Lock(passphrase); err != nil {
+		return false, nil
+	}
+	if err := client.Unlock(passphrase); err != nil {
+		return true, fmt.Errorf("unlock after probe failed: %s", err)
+	}
+	return true, nil
+}
+
+// parseStrings parses a sequence of SSH strings, dropping a malformed end.
+func parseStrings(data []byte) []string {
+	var result []string
+	for len(data) > 0 {
+		var s struct {
+			Value string
+			Rest  []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(data, &s); err != nil {
+			break
+		}
+		result = append(result, s.Value)
+		data = s.Rest
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// queryAgent answers the query extension as Pageant, and its named pipe,
+// do, and like them refuses constraints and locks.
+type queryAgent struct {
+	agent.ExtendedAgent
+}
+
+func (a queryAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	if extensionType != "query" {
+		return nil, agent.ErrExtensionUnsupported
+	}
+	return append([]byte{agentSuccess}, ssh.Marshal(struct{ A, B string }{"add-ppk@putty.projects.tartarus.org", "list-extended@putty.projects.tartarus.org"})...), nil
+}
+
+func (a queryAgent) Add(key agent.AddedKey) error {
+	if key.LifetimeSecs != 0 || key.ConfirmBeforeUse {
+		return errors.New("constraints unsupported")
+	}
+	return a.ExtendedAgent.Add(key)
+}
+
+func (a queryAgent) Lock(passphrase []byte) error {
+	return errors.New("lock unsupported")
+}
+
+func TestCapabilities(t *testing.T) {
+	for _, query := range []bool{false, true} {
+		keyring := agent.NewKeyring().(agent.ExtendedAgent)
+		if query {
+			keyring = queryAgent{keyring}
+		}
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on ed25519.GenerateKey: %s", err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+		client, server := net.Pipe()
+		go agent.ServeAgent(keyring, server)
+
+		caps, err := Capabilities(client)
+		if err != nil {
+			t.Fatalf("error on Capabilities: %s", err)
+		}
+		if caps.Query != query || len(caps.Extensions) != map[bool]int{false: 0, true: 2}[query] {
+			t.Fatalf("Capabilities with query %v found %+v", query, caps)
+		}
+		if caps.Lock == query || caps.Constraints == query || caps.MaxMessageSize != 256*1024 {
+			t.Fatalf("Capabilities with query %v found %+v", query, caps)
+		}
+		// the probes leave neither their key nor a lock behind
+		if keys, err := agent.NewClient(client).List(); err != nil || len(keys) != 1 {
+			t.Fatalf("List after Capabilities found %d keys, %v", len(keys), err)
+		}
+		data, err := json.Marshal(caps)
+		if err != nil || !strings.Contains(string(data), `"max_message_size":262144`) {
+			t.Fatalf("JSON of capabilities is %s, %v", data, err)
+		}
+		client.Close()
+	}
+}
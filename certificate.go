@@ -0,0 +1,141 @@
+package pageant
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SelectCertificates returns the certificates among keys which are valid
+// now and have a principal matching principal, or no principals at all and
+// so are valid for any, the certificate valid for the longest first.
+// principal may hold * and ? wildcards, such as deploy-* or *.example.com,
+// matched against the principals of certificates taken literally.
+func SelectCertificates(keys []*agent.Key, principal string) []*agent.Key {
+	now := uint64(time.Now().Unix())
+	var selected []*agent.Key
+	var validBefore []uint64
+	for _, key := range keys {
+		cert := parseCertificate(key)
+		if cert == nil || now < cert.ValidAfter || now >= cert.ValidBefore {
+			continue
+		}
+		matches := len(cert.ValidPrincipals) == 0
+		for _, p := range cert.ValidPrincipals {
+			if matchPattern(principal, p) {
+				matches = true
+				break
+			}
+		}
+		if matches {
+			selected = append(selected, key)
+			validBefore = append(validBefore, cert.ValidBefore)
+		}
+	}
+	sort.Stable(byValidBefore{selected, validBefore})
+	return selected
+}
+
+// CertificateSelector returns a Selector offering the certificates
+// SelectCertificates picks for principal, followed by the plain keys,
+// leaving out the certificates the server would refuse.
+func CertificateSelector(principal string) Selector {
+	return func(_ string, keys []*agent.Key) []*agent.Key {
+		selected := SelectCertificates(keys, principal)
+		for _, key := range keys {
+			if parseCertificate(key) == nil {
+				selected = append(selected, key)
+			}
+		}
+		return selected
+	}
+}
+
+// RemoveExpiredCertificates removes from a the certificates which are no
+// longer valid, so that servers limiting the authentication attempts are
+// not offered them, and returns how many were removed.
+func RemoveExpiredCertificates(a agent.Agent) (int, error) {
+	return removeExpired(a, nil)
+}
+
+// removeExpired is RemoveExpiredCertificates for the keys keep returns true
+// for, or all keys if keep is nil.
+func removeExpired(a agent.Agent, keep func(key *agent.Key) bool) (int, error) {
+	keys, err := a.List()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	removed := 0
+	for _, key := range keys {
+		cert := parseCertificate(key)
+		if !expired(cert, now) || (keep != nil && !keep(key)) {
+			continue
+		}
+		if err := a.Remove(cert); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// expired tells whether cert is a certificate no longer valid at now.
+func expired(cert *ssh.Certificate, now time.Time) bool {
+	return cert != nil && cert.ValidBefore != ssh.CertTimeInfinity && uint64(now.Unix()) >= cert.ValidBefore
+}
+
+// parseCertificate returns the certificate of key, or nil if key is not one.
+func parseCertificate(key *agent.Key) *ssh.Certificate {
+	pub, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return nil
+	}
+	cert, _ := pub.(*ssh.Certificate)
+	return cert
+}
+
+// byValidBefore sorts keys by decreasing validity.
+type byValidBefore struct {
+	keys        []*agent.Key
+	validBefore []uint64
+}
+
+func (s byValidBefore) Len() int           { return len(s.keys) }
+func (s byValidBefore) Less(i, j int) bool { return s.validBefore[i] > s.validBefore[j] }
+func (s byValidBefore) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.validBefore[i], s.validBefore[j] = s.validBefore[j], s.validBefore[i]
+}
+
+// matchPattern tells whether s matches pattern, where * matches any run of
+// characters and ? any single character, as in OpenSSH patterns.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
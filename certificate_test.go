@@ -0,0 +1,171 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSelectCertificates(t *testing.T) {
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on GenerateKey: %s", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatalf("error on NewSignerFromKey: %s", err)
+	}
+	now := uint64(time.Now().Unix())
+	keyring := agent.NewKeyring()
+	for _, c := range []struct {
+		id          string
+		principals  []string
+		validBefore uint64
+	}{
+		{"short", []string{"deploy"}, now + 60},
+		{"long", []string{"deploy", "admin"}, now + 3600},
+		{"expired", []string{"deploy"}, now - 60},
+		{"wildcard", []string{"build-*"}, ssh.CertTimeInfinity},
+		{"other", []string{"admin"}, now + 7200},
+		{"any", nil, now + 10800},
+	} {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on GenerateKey: %s", err)
+		}
+		sshPub, _ := ssh.NewPublicKey(pub)
+		cert := &ssh.Certificate{
+			Key:             sshPub,
+			KeyId:           c.id,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: c.principals,
+			ValidAfter:      now - 120,
+			ValidBefore:     c.validBefore,
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatalf("error on SignCert: %s", err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Certificate: cert, Comment: c.id}); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+	}
+	keys, err := keyring.List()
+	if err != nil {
+		t.Fatalf("error on List: %s", err)
+	}
+
+	for principal, want := range map[string][]string{
+		"deploy":   {"any", "long", "short"},
+		"dep*":     {"any", "long", "short"},
+		"build-*":  {"wildcard", "any"},
+		"build-42": {"any"},
+		"nobody":   {"any"},
+	} {
+		selected := SelectCertificates(keys, principal)
+		var got []string
+		for _, key := range selected {
+			got = append(got, parseCertificate(key).KeyId)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("SelectCertificates(%q) = %v, want %v", principal, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("SelectCertificates(%q) = %v, want %v", principal, got, want)
+			}
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"deploy", "deploy", true},
+		{"deploy", "deployer", false},
+		{"*.example.com", "db.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"web-??", "web-01", true},
+		{"web-??", "web-1", false},
+		{"*", "", true},
+	} {
+		if match := matchPattern(test.pattern, test.s); match != test.match {
+			t.Errorf("matchPattern(%q, %q) = %v", test.pattern, test.s, match)
+		}
+	}
+}
+
+func TestRemoveExpiredCertificates(t *testing.T) {
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on GenerateKey: %s", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatalf("error on NewSignerFromKey: %s", err)
+	}
+	now := uint64(time.Now().Unix())
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	for i, validBefore := range []uint64{0, now - 60, now - 30, now + 3600, ssh.CertTimeInfinity} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on GenerateKey: %s", err)
+		}
+		added := agent.AddedKey{PrivateKey: priv, Comment: "plain"}
+		if validBefore != 0 {
+			signer, _ := ssh.NewSignerFromKey(priv)
+			cert := &ssh.Certificate{
+				Key:         signer.PublicKey(),
+				CertType:    ssh.UserCert,
+				ValidAfter:  now - 120,
+				ValidBefore: validBefore,
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				t.Fatalf("error on SignCert: %s", err)
+			}
+			added.Certificate = cert
+			added.Comment = "cert"
+			if validBefore < now {
+				added.Comment = fmt.Sprintf("expired-%d", i)
+			}
+		}
+		if err := keyring.Add(added); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+	}
+
+	proxy := NewProxy(ProxyConfig{Upstreams: []agent.ExtendedAgent{keyring}, HideExpired: true})
+	if keys, err := proxy.List(); err != nil || len(keys) != 3 {
+		t.Fatalf("Proxy with HideExpired listed %d keys rather than 3: %v", len(keys), err)
+	}
+	if keys, _ := keyring.List(); len(keys) != 5 {
+		t.Fatalf("Proxy with HideExpired removed keys of its upstream")
+	}
+
+	proxy = NewProxy(ProxyConfig{
+		Upstreams: []agent.ExtendedAgent{keyring},
+		Allow:     func(key *agent.Key) bool { return key.Comment != "expired-2" },
+	})
+	if removed, err := proxy.RemoveExpired(); err != nil || removed != 1 {
+		t.Fatalf("Proxy.RemoveExpired removed %d certificates rather than 1: %v", removed, err)
+	}
+	if removed, err := RemoveExpiredCertificates(keyring); err != nil || removed != 1 {
+		t.Fatalf("RemoveExpiredCertificates removed %d certificates rather than 1: %v", removed, err)
+	}
+	keys, _ := keyring.List()
+	for _, key := range keys {
+		if strings.HasPrefix(key.Comment, "expired") {
+			t.Fatalf("RemoveExpiredCertificates left an expired certificate")
+		}
+	}
+	if len(keys) != 3 {
+		t.Fatalf("RemoveExpiredCertificates left %d keys rather than 3", len(keys))
+	}
+}
@@ -0,0 +1,58 @@
+// Command pageant-doctor reports which SSH agents are reachable, the keys
+// they hold and hints about how to fix the agents that are not, so that
+// support requests can start with its output.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/trzsz/pageant"
+)
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	reports := pageant.Diagnose()
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			fmt.Fprintf(os.Stderr, "pageant-doctor: %s\n", err)
+			os.Exit(2)
+		}
+	} else {
+		printReports(reports)
+	}
+
+	for _, report := range reports {
+		if len(report.Keys) > 0 {
+			return
+		}
+	}
+	os.Exit(1)
+}
+
+func printReports(reports []pageant.AgentReport) {
+	fmt.Printf("%s/%s SSH_AUTH_SOCK=%q\n", runtime.GOOS, runtime.GOARCH, os.Getenv("SSH_AUTH_SOCK"))
+	for _, report := range reports {
+		switch {
+		case report.Error != "" && report.Found:
+			fmt.Printf("\n%s: found, failed to list keys: %s\n", report.Backend, report.Error)
+		case report.Error != "":
+			fmt.Printf("\n%s: not found: %s\n", report.Backend, report.Error)
+		default:
+			fmt.Printf("\n%s: found, %d keys\n", report.Backend, len(report.Keys))
+		}
+		for _, key := range report.Keys {
+			fmt.Printf("  %s %s %s\n", key.Type, key.Fingerprint, key.Comment)
+		}
+		for _, hint := range report.Hints {
+			fmt.Printf("  hint: %s\n", hint)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Command pageant-export prints the public keys held by the agent the
+// library selects, for provisioning scripts and inventories:
+//
+//	pageant-export -format authorized_keys >> ~/.ssh/authorized_keys
+//	pageant-export -format allowed_signers -principal me@example.com
+//	pageant-export -format json
+//
+// Certificates are exported as their key, with their principals and
+// validity in allowed_signers and in full in JSON.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// identity is an agent key as exported in JSON.
+type identity struct {
+	Fingerprint string       `json:"fingerprint"`
+	Type        string       `json:"type"`
+	Comment     string       `json:"comment,omitempty"`
+	PublicKey   string       `json:"public_key"`
+	Certificate *certificate `json:"certificate,omitempty"`
+}
+
+// certificate is the part of an ssh.Certificate exported in JSON.
+type certificate struct {
+	KeyID         string     `json:"key_id"`
+	Serial        uint64     `json:"serial"`
+	Principals    []string   `json:"principals,omitempty"`
+	CAFingerprint string     `json:"ca_fingerprint"`
+	ValidAfter    *time.Time `json:"valid_after,omitempty"`
+	ValidBefore   *time.Time `json:"valid_before,omitempty"`
+	Expired       bool       `json:"expired"`
+}
+
+func main() {
+	format := flag.String("format", "authorized_keys", "output format, authorized_keys, allowed_signers or json")
+	principal := flag.String("principal", "", "principal of the keys without certificate in allowed_signers, instead of their comment")
+	backend := flag.String("backend", "", "agent backend to use, pageant, pipe or unix, instead of the default ones")
+	agentPath := flag.String("agent", "", "named pipe or unix socket of the agent, instead of SSH_AUTH_SOCK")
+	flag.Parse()
+
+	opts := &pageant.Options{AgentPath: *agentPath}
+	if *backend != "" {
+		opts.Backends = []string{*backend}
+	}
+	conn, err := pageant.NewConnWithOptions(opts)
+	if err != nil {
+		fatal(err)
+	}
+	keys, err := agent.NewClient(conn).List()
+	conn.Close()
+	if err != nil {
+		fatal(err)
+	}
+
+	var output []byte
+	switch *format {
+	case "authorized_keys":
+		output, err = authorizedKeys(keys)
+	case "allowed_signers":
+		output, err = allowedSigners(keys, *principal)
+	case "json":
+		output, err = identities(keys)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout.Write(output)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "pageant-export: %s\n", err)
+	os.Exit(1)
+}
+
+// parseKey returns the public key of key and its certificate, if it is one.
+func parseKey(key *agent.Key) (ssh.PublicKey, *ssh.Certificate, error) {
+	pub, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key %s: %s", key.Comment, err)
+	}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		return cert.Key, cert, nil
+	}
+	return pub, nil, nil
+}
+
+// authorizedKeys returns a line per key, once per key when it also comes
+// with certificates.
+func authorizedKeys(keys []*agent.Key) ([]byte, error) {
+	var output bytes.Buffer
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		pub, _, err := parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[string(pub.Marshal())] {
+			continue
+		}
+		seen[string(pub.Marshal())] = true
+		output.Write(bytes.TrimSuffix(ssh.MarshalAuthorizedKey(pub), []byte("\n")))
+		if key.Comment != "" {
+			output.WriteString(" " + key.Comment)
+		}
+		output.WriteString("\n")
+	}
+	return output.Bytes(), nil
+}
+
+// allowedSigners returns a line per key for ssh-keygen -Y verify, naming
+// the principals of certificates and principal, or the comment, otherwise.
+func allowedSigners(keys []*agent.Key, principal string) ([]byte, error) {
+	var output bytes.Buffer
+	for _, key := range keys {
+		pub, cert, err := parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		principals := principal
+		if principals == "" {
+			principals = key.Comment
+		}
+		var options []string
+		if cert != nil {
+			if len(cert.ValidPrincipals) == 0 {
+				continue
+			}
+			principals = strings.Join(cert.ValidPrincipals, ",")
+			if cert.ValidAfter != 0 {
+				options = append(options, "valid-after="+signerTime(cert.ValidAfter))
+			}
+			if cert.ValidBefore != ssh.CertTimeInfinity {
+				options = append(options, "valid-before="+signerTime(cert.ValidBefore))
+			}
+		}
+		if principals == "" || strings.ContainsAny(principals, " \t") {
+			return nil, fmt.Errorf("no principal for key %s, set -principal", ssh.FingerprintSHA256(pub))
+		}
+		output.WriteString(principals + " ")
+		if len(options) > 0 {
+			output.WriteString(strings.Join(options, ",") + " ")
+		}
+		output.Write(ssh.MarshalAuthorizedKey(pub))
+	}
+	return output.Bytes(), nil
+}
+
+// signerTime formats a certificate time as allowed_signers expects it.
+func signerTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405") + "Z"
+}
+
+// identities returns the keys as a JSON array.
+func identities(keys []*agent.Key) ([]byte, error) {
+	result := make([]identity, 0, len(keys))
+	for _, key := range keys {
+		pub, cert, err := parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		id := identity{
+			Fingerprint: ssh.FingerprintSHA256(pub),
+			Type:        key.Format,
+			Comment:     key.Comment,
+			PublicKey:   strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pub)), "\n"),
+		}
+		if cert != nil {
+			id.Certificate = &certificate{
+				KeyID:         cert.KeyId,
+				Serial:        cert.Serial,
+				Principals:    cert.ValidPrincipals,
+				CAFingerprint: ssh.FingerprintSHA256(cert.SignatureKey),
+				Expired:       cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore),
+			}
+			if cert.ValidAfter != 0 {
+				validAfter := time.Unix(int64(cert.ValidAfter), 0).UTC()
+				id.Certificate.ValidAfter = &validAfter
+			}
+			if cert.ValidBefore != ssh.CertTimeInfinity {
+				validBefore := time.Unix(int64(cert.ValidBefore), 0).UTC()
+				id.Certificate.ValidBefore = &validBefore
+			}
+		}
+		result = append(result, id)
+	}
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(output, '\n'), nil
+}
@@ -0,0 +1,338 @@
+// Command pageant-keys manages the keys of the agent the library selects,
+// Pageant, the OpenSSH agent service or SSH_AUTH_SOCK, as ssh-add does:
+//
+//	pageant-keys list [-public]
+//	pageant-keys add [-t LIFETIME] [-c] FILE...
+//	pageant-keys remove FINGERPRINT|FILE.pub...
+//	pageant-keys remove-all
+//	pageant-keys lock
+//	pageant-keys unlock
+//	pageant-keys stats [-json]
+//
+// add reads OpenSSH, PEM and PuTTY .ppk private keys, asking for their
+// passphrase if they are encrypted, along with their FILE-cert.pub
+// certificates. Pageant itself does not support -t and -c.
+// stats reports how often each key was used, by pageant-server and
+// pageant-proxy only.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: pageant-keys [-backend BACKEND] [-agent PATH] COMMAND [ARGS]
+
+commands:
+  list [-public]                      list the fingerprints, or the public keys, of the keys
+  add [-t LIFETIME] [-c] FILE...      add private keys, for LIFETIME, confirming each use
+  remove FINGERPRINT|FILE.pub...      remove keys
+  remove-all                          remove all keys
+  lock                                lock the agent with a passphrase
+  unlock                              unlock the agent
+  stats [-json]                       report the usage of the keys of pageant-server or pageant-proxy
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	backend := flag.String("backend", "", "agent backend to use, pageant, pipe or unix, instead of the default ones")
+	agentPath := flag.String("agent", "", "named pipe or unix socket of the agent, instead of SSH_AUTH_SOCK")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts := &pageant.Options{AgentPath: *agentPath}
+	if *backend != "" {
+		opts.Backends = []string{*backend}
+	}
+	conn, err := pageant.NewConnWithOptions(opts)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+	client := agent.NewClient(conn)
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "list":
+		err = list(client, args)
+	case "add":
+		err = add(client, args)
+	case "remove":
+		err = remove(client, args)
+	case "remove-all":
+		err = client.RemoveAll()
+		if err == nil {
+			fmt.Println("All identities removed.")
+		}
+	case "lock":
+		err = lock(client)
+	case "unlock":
+		err = unlock(client)
+	case "stats":
+		err = stats(client, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "pageant-keys: %s\n", err)
+	os.Exit(1)
+}
+
+func list(client agent.ExtendedAgent, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	public := flags.Bool("public", false, "print the public keys rather than their fingerprints")
+	flags.Parse(args)
+
+	keys, err := client.List()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("the agent has no identities")
+	}
+	for _, key := range keys {
+		if *public {
+			fmt.Println(key.String())
+			continue
+		}
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s (%s)\n", ssh.FingerprintSHA256(pub), key.Comment, key.Format)
+	}
+	return nil
+}
+
+func stats(client agent.ExtendedAgent, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the usage as JSON")
+	flags.Parse(args)
+
+	usage, err := pageant.AgentStats(client)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, key := range usage {
+		lastUsed := "never"
+		if !key.LastUsed.IsZero() {
+			lastUsed = key.LastUsed.Local().Format(time.RFC3339)
+		}
+		fmt.Printf("%s %s: %d signatures, %d failures, last used %s", key.Fingerprint, key.Comment, key.Signs, key.Failures, lastUsed)
+		if key.LastPeer != "" {
+			fmt.Printf(" by %s", key.LastPeer)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func add(client agent.ExtendedAgent, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	lifetime := flags.Duration("t", 0, "remove the keys after this long")
+	confirm := flags.Bool("c", false, "confirm each use of the keys")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no key file to add")
+	}
+
+	for _, path := range flags.Args() {
+		key, comment, err := readKey(path)
+		if err != nil {
+			return err
+		}
+		added := agent.AddedKey{
+			PrivateKey:       key,
+			Comment:          comment,
+			LifetimeSecs:     uint32(*lifetime / time.Second),
+			ConfirmBeforeUse: *confirm,
+		}
+		if err := client.Add(added); err != nil {
+			return fmt.Errorf("failed to add %s: %s", path, err)
+		}
+		fmt.Printf("Identity added: %s (%s)\n", path, comment)
+		if cert := readCertificate(path); cert != nil {
+			added.Certificate = cert
+			if err := client.Add(added); err != nil {
+				return fmt.Errorf("failed to add certificate of %s: %s", path, err)
+			}
+			fmt.Printf("Certificate added: %s-cert.pub (%s)\n", path, cert.KeyId)
+		}
+	}
+	return nil
+}
+
+// readKey reads the private key at path and its comment, asking for its
+// passphrase if it is encrypted.
+func readKey(path string) (interface{}, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	ppk := bytes.HasPrefix(data, []byte("PuTTY-User-Key-File-"))
+
+	var key interface{}
+	var comment string
+	if ppk {
+		key, comment, err = pageant.ParsePuTTYKey(data)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(data)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, err := readPassphrase(fmt.Sprintf("Enter passphrase for %s: ", path))
+		if err != nil {
+			return nil, "", err
+		}
+		if ppk {
+			key, comment, err = pageant.ParsePuTTYKeyWithPassphrase(data, passphrase)
+		} else {
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid private key %s: %s", path, err)
+		}
+	} else if err != nil {
+		return nil, "", fmt.Errorf("invalid private key %s: %s", path, err)
+	}
+
+	if comment == "" {
+		comment = path
+		if pubData, err := os.ReadFile(path + ".pub"); err == nil {
+			if _, c, _, _, err := ssh.ParseAuthorizedKey(pubData); err == nil && c != "" {
+				comment = c
+			}
+		}
+	}
+	return key, comment, nil
+}
+
+// readCertificate returns the certificate next to the private key at path,
+// or nil if there is none.
+func readCertificate(path string) *ssh.Certificate {
+	data, err := os.ReadFile(strings.TrimSuffix(path, ".ppk") + "-cert.pub")
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+	cert, _ := pub.(*ssh.Certificate)
+	return cert
+}
+
+func remove(client agent.ExtendedAgent, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no key to remove")
+	}
+	keys, err := client.List()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		fingerprint := arg
+		if !strings.HasPrefix(arg, "SHA256:") {
+			data, err := os.ReadFile(arg)
+			if err != nil {
+				return err
+			}
+			pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return fmt.Errorf("invalid public key %s: %s", arg, err)
+			}
+			fingerprint = ssh.FingerprintSHA256(pub)
+		}
+		found := false
+		for _, key := range keys {
+			pub, err := ssh.ParsePublicKey(key.Blob)
+			if err != nil || ssh.FingerprintSHA256(pub) != fingerprint {
+				continue
+			}
+			if err := client.Remove(pub); err != nil {
+				return fmt.Errorf("failed to remove %s: %s", arg, err)
+			}
+			found = true
+			fmt.Printf("Identity removed: %s (%s)\n", arg, key.Comment)
+		}
+		if !found {
+			return fmt.Errorf("the agent has no identity %s", arg)
+		}
+	}
+	return nil
+}
+
+func lock(client agent.ExtendedAgent) error {
+	passphrase, err := readPassphrase("Enter lock password: ")
+	if err != nil {
+		return err
+	}
+	again, err := readPassphrase("Again: ")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(passphrase, again) {
+		return fmt.Errorf("passwords do not match")
+	}
+	if err := client.Lock(passphrase); err != nil {
+		return fmt.Errorf("failed to lock agent: %s", err)
+	}
+	fmt.Println("Agent locked.")
+	return nil
+}
+
+func unlock(client agent.ExtendedAgent) error {
+	passphrase, err := readPassphrase("Enter lock password: ")
+	if err != nil {
+		return err
+	}
+	if err := client.Unlock(passphrase); err != nil {
+		return fmt.Errorf("failed to unlock agent: %s", err)
+	}
+	fmt.Println("Agent unlocked.")
+	return nil
+}
+
+// readPassphrase asks for a passphrase on the terminal without echoing it.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %s", err)
+	}
+	return passphrase, nil
+}
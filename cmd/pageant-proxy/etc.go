@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serve serves proxy on address until the returned function is called.
+func serve(address string, proxy agent.Agent) (func() error, error) {
+	return serveListener(address, proxy)
+}
+
+// confirmDialog refuses prompt as there is no dialog without SSH_ASKPASS.
+func confirmDialog(prompt string) bool {
+	log.Printf("pageant-proxy: no confirm_command nor SSH_ASKPASS to confirm: %s", prompt)
+	return false
+}
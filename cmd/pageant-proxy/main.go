@@ -0,0 +1,209 @@
+// Command pageant-proxy serves an agent offering the keys of other agents,
+// filtered and confirmed as set in a JSON configuration file:
+//
+//	{
+//		"listen": "unix:/home/me/.ssh/proxy.sock",
+//		"upstreams": ["pageant", "pipe", "unix:/run/user/1000/ssh-agent.sock"],
+//		"allow": ["*@work"],
+//		"deny": ["SHA256:AbCd..."],
+//		"confirm": ["*"],
+//		"confirm_command": "ssh-askpass",
+//		"hide_expired": true,
+//		"remove_expired": "1h"
+//	}
+//
+// listen is unix:PATH, pipe:PATH or, on Windows, pageant to answer PuTTY
+// through a Pageant window. upstreams are agent backends, pageant, pipe and
+// unix, the last two optionally followed by :PATH instead of SSH_AUTH_SOCK.
+// Patterns of allow, deny and confirm match SHA256 fingerprints exactly and
+// key comments as path.Match patterns.
+// A key needing confirmation is confirmed by confirm_command, or
+// SSH_ASKPASS, exiting successfully, and on Windows by default by a dialog.
+// hide_expired hides the expired certificates, from servers limiting the
+// authentication attempts in particular, and remove_expired removes them
+// from the upstreams that often, as a time.ParseDuration string.
+// The usage of each key is reported by pageant-keys stats.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type config struct {
+	Listen         string   `json:"listen"`
+	Upstreams      []string `json:"upstreams"`
+	Allow          []string `json:"allow"`
+	Deny           []string `json:"deny"`
+	Confirm        []string `json:"confirm"`
+	ConfirmCommand string   `json:"confirm_command"`
+	HideExpired    bool     `json:"hide_expired"`
+	RemoveExpired  string   `json:"remove_expired"`
+
+	removeExpired time.Duration
+}
+
+func main() {
+	configPath := flag.String("config", "pageant-proxy.json", "path of the configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+	proxy, err := newProxy(cfg)
+	if err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+	deployed, err := pageant.LoadConfig()
+	if err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+	served, err := deployed.OpenAudit(proxy)
+	if err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+	if cfg.removeExpired > 0 {
+		go pruneCertificates(proxy, cfg.removeExpired)
+	}
+	stop, err := serve(cfg.Listen, pageant.NewStatsAgent(served))
+	if err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+	log.Printf("pageant-proxy: serving %d upstream agents on %s", len(cfg.Upstreams), cfg.Listen)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	if err := stop(); err != nil {
+		log.Fatalf("pageant-proxy: %s", err)
+	}
+}
+
+func loadConfig(configPath string) (*config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %s", configPath, err)
+	}
+	if cfg.Listen == "" {
+		return nil, fmt.Errorf("no listen address in %s", configPath)
+	}
+	if len(cfg.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream agents in %s", configPath)
+	}
+	if cfg.RemoveExpired != "" {
+		if cfg.removeExpired, err = time.ParseDuration(cfg.RemoveExpired); err != nil || cfg.removeExpired <= 0 {
+			return nil, fmt.Errorf("invalid remove_expired %q in %s", cfg.RemoveExpired, configPath)
+		}
+	}
+	for _, pattern := range append(append(append([]string{}, cfg.Allow...), cfg.Deny...), cfg.Confirm...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s", pattern, configPath)
+		}
+	}
+	return cfg, nil
+}
+
+func newProxy(cfg *config) (*pageant.Proxy, error) {
+	upstreams := make([]agent.ExtendedAgent, 0, len(cfg.Upstreams))
+	for _, upstream := range cfg.Upstreams {
+		backend, agentPath, _ := strings.Cut(upstream, ":")
+		switch backend {
+		case pageant.BackendPageant, pageant.BackendPipe, pageant.BackendUnix:
+		default:
+			return nil, fmt.Errorf("unknown upstream agent %q", upstream)
+		}
+		opts := &pageant.Options{Backends: []string{backend}, AgentPath: agentPath}
+		upstreams = append(upstreams, pageant.NewPool(4, opts).Agent())
+	}
+	return pageant.NewProxy(pageant.ProxyConfig{
+		Upstreams: upstreams,
+		Allow: func(key *agent.Key) bool {
+			return (len(cfg.Allow) == 0 || matchKey(cfg.Allow, key)) && !matchKey(cfg.Deny, key)
+		},
+		Confirm: func(key *agent.Key) bool {
+			return !matchKey(cfg.Confirm, key) || confirm(cfg.ConfirmCommand, key)
+		},
+		HideExpired: cfg.HideExpired,
+	}), nil
+}
+
+// pruneCertificates removes the expired certificates of the upstreams of
+// proxy every interval.
+func pruneCertificates(proxy *pageant.Proxy, interval time.Duration) {
+	for range time.Tick(interval) {
+		if removed, err := proxy.RemoveExpired(); err != nil {
+			log.Printf("pageant-proxy: failed to remove expired certificates: %s", err)
+		} else if removed > 0 {
+			log.Printf("pageant-proxy: removed %d expired certificates", removed)
+		}
+	}
+}
+
+// matchKey tells whether key matches one of patterns.
+func matchKey(patterns []string, key *agent.Key) bool {
+	fingerprint := fingerprint(key)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "SHA256:") {
+			if pattern == fingerprint {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern, key.Comment); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprint(key *agent.Key) string {
+	pub, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(pub)
+}
+
+// confirm asks the user whether key may be used.
+func confirm(command string, key *agent.Key) bool {
+	prompt := pageant.Text(pageant.MessageConfirmKey, key.Comment, fingerprint(key))
+	if command == "" {
+		command = os.Getenv("SSH_ASKPASS")
+	}
+	if command == "" {
+		return confirmDialog(prompt)
+	}
+	cmd := exec.Command(command, prompt)
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
+
+// serveListener serves proxy on a unix socket or named pipe.
+func serveListener(address string, proxy agent.Agent) (func() error, error) {
+	listener, err := pageant.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := pageant.Serve(proxy, listener); err != nil {
+			log.Printf("pageant-proxy: %s", err)
+		}
+	}()
+	return listener.Close, nil
+}
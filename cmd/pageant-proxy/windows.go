@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/sys/windows"
+)
+
+// serve serves proxy on address until the returned function is called.
+func serve(address string, proxy agent.Agent) (func() error, error) {
+	if address != "pageant" {
+		return serveListener(address, proxy)
+	}
+	server := pageant.NewServer(proxy)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server.Stop, nil
+}
+
+// idYes is the IDYES answer of MessageBox.
+const idYes = 6
+
+// confirmDialog asks the user to confirm prompt in a message box.
+func confirmDialog(prompt string) bool {
+	text, err := windows.UTF16PtrFromString(prompt)
+	if err != nil {
+		return false
+	}
+	title, _ := windows.UTF16PtrFromString("pageant-proxy")
+	answer, _ := windows.MessageBox(0, text, title,
+		windows.MB_YESNO|windows.MB_ICONQUESTION|windows.MB_SYSTEMMODAL|windows.MB_SETFOREGROUND)
+	return answer == idYes
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serve serves a on address until the returned function is called.
+func serve(address string, a agent.Agent) (func() error, error) {
+	return serveListener(address, a)
+}
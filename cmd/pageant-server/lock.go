@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// idleLock serves an agent, locking it with passphrase once no request
+// came for idle.
+type idleLock struct {
+	agent      agent.ExtendedAgent
+	idle       time.Duration
+	passphrase []byte
+	mu         sync.Mutex
+	timer      *time.Timer
+}
+
+func newIdleLock(a agent.ExtendedAgent, idle time.Duration, passphrase []byte) *idleLock {
+	l := &idleLock{agent: a, idle: idle, passphrase: passphrase}
+	l.timer = time.AfterFunc(idle, l.lock)
+	return l
+}
+
+// touch postpones locking by another idle period.
+func (l *idleLock) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timer.Reset(l.idle)
+}
+
+func (l *idleLock) lock() {
+	// a locked agent refuses to be locked again, which is fine
+	if err := l.agent.Lock(l.passphrase); err == nil {
+		log.Printf("pageant-server: %s", pageant.Text(pageant.MessageIdleLocked, l.idle))
+	}
+}
+
+func (l *idleLock) List() ([]*agent.Key, error) {
+	l.touch()
+	return l.agent.List()
+}
+
+func (l *idleLock) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	l.touch()
+	return l.agent.Sign(key, data)
+}
+
+func (l *idleLock) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	l.touch()
+	return l.agent.SignWithFlags(key, data, flags)
+}
+
+func (l *idleLock) Add(key agent.AddedKey) error {
+	l.touch()
+	return l.agent.Add(key)
+}
+
+func (l *idleLock) Remove(key ssh.PublicKey) error {
+	l.touch()
+	return l.agent.Remove(key)
+}
+
+func (l *idleLock) RemoveAll() error {
+	l.touch()
+	return l.agent.RemoveAll()
+}
+
+func (l *idleLock) Lock(passphrase []byte) error {
+	l.touch()
+	return l.agent.Lock(passphrase)
+}
+
+func (l *idleLock) Unlock(passphrase []byte) error {
+	l.touch()
+	return l.agent.Unlock(passphrase)
+}
+
+func (l *idleLock) Signers() ([]ssh.Signer, error) {
+	l.touch()
+	return l.agent.Signers()
+}
+
+func (l *idleLock) Extension(extensionType string, contents []byte) ([]byte, error) {
+	l.touch()
+	return l.agent.Extension(extensionType, contents)
+}
@@ -0,0 +1,204 @@
+// Command pageant-server is an SSH agent written in Go, holding its keys in
+// memory and serving them on any number of listeners:
+//
+//	pageant-server -listen unix:$HOME/.ssh/agent.sock -keys $HOME/.ssh
+//	pageant-server -listen pageant -listen pipe:openssh-ssh-agent
+//
+// Listeners are unix:PATH, pipe:PATH or, on Windows, pageant to answer
+// PuTTY through a Pageant window, replacing ssh-agent and Pageant alike.
+// The unencrypted private keys of the -keys directory are loaded at start,
+// along with their KEY-cert.pub certificates; more keys can be added with
+// ssh-add. With -lock-after, the agent locks itself once idle for that
+// long, to be unlocked by ssh-add -X with the passphrase of -passphrase-file.
+// With -prune-expired, expired certificates are removed periodically, so
+// that servers limiting the authentication attempts are not offered them.
+// The usage of each key is reported by pageant-keys stats.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// listenFlag collects the repeated -listen flags.
+type listenFlag []string
+
+func (l *listenFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenFlag) Set(address string) error {
+	*l = append(*l, address)
+	return nil
+}
+
+func main() {
+	var listen listenFlag
+	flag.Var(&listen, "listen", "address to serve the agent on, unix:PATH, pipe:PATH or pageant; repeatable")
+	keyDir := flag.String("keys", "", "directory of private keys to load at start")
+	lockAfter := flag.Duration("lock-after", 0, "lock the agent once idle for this long, 0 to never lock")
+	passphraseFile := flag.String("passphrase-file", "", "file holding the passphrase -lock-after locks with")
+	pruneExpired := flag.Duration("prune-expired", 0, "remove the expired certificates this often, 0 to keep them")
+	flag.Parse()
+
+	if len(listen) == 0 {
+		log.Fatalf("pageant-server: no -listen address")
+	}
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	if *keyDir != "" {
+		if err := loadKeys(keyring, *keyDir); err != nil {
+			log.Fatalf("pageant-server: %s", err)
+		}
+	}
+
+	deployed, err := pageant.LoadConfig()
+	if err != nil {
+		log.Fatalf("pageant-server: %s", err)
+	}
+	served, err := deployed.OpenAudit(keyring)
+	if err != nil {
+		log.Fatalf("pageant-server: %s", err)
+	}
+	if *pruneExpired > 0 {
+		go pruneCertificates(served, *pruneExpired)
+	}
+	if *lockAfter > 0 {
+		if *passphraseFile == "" {
+			log.Fatalf("pageant-server: -lock-after needs a -passphrase-file")
+		}
+		passphrase, err := os.ReadFile(*passphraseFile)
+		if err != nil {
+			log.Fatalf("pageant-server: %s", err)
+		}
+		served = newIdleLock(served, *lockAfter, bytes.TrimRight(passphrase, "\r\n"))
+	}
+	served = pageant.NewStatsAgent(served)
+
+	var stops []func() error
+	for _, address := range listen {
+		stop, err := serve(address, served)
+		if err != nil {
+			log.Fatalf("pageant-server: %s", err)
+		}
+		stops = append(stops, stop)
+		log.Printf("pageant-server: serving on %s", address)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	for _, stop := range stops {
+		if err := stop(); err != nil {
+			log.Printf("pageant-server: %s", err)
+		}
+	}
+}
+
+// pruneCertificates removes the expired certificates of a every interval.
+func pruneCertificates(a agent.Agent, interval time.Duration) {
+	for range time.Tick(interval) {
+		if removed, err := pageant.RemoveExpiredCertificates(a); err != nil {
+			log.Printf("pageant-server: failed to remove expired certificates: %s", err)
+		} else if removed > 0 {
+			log.Printf("pageant-server: removed %d expired certificates", removed)
+		}
+	}
+}
+
+// loadKeys adds to keyring the unencrypted private keys of dir, skipping
+// the files which are not private keys.
+func loadKeys(keyring agent.Agent, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".pub") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			continue
+		}
+		key, err := ssh.ParseRawPrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			log.Printf("pageant-server: skipping encrypted key %s, add it with ssh-add", path)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("invalid private key %s: %s", path, err)
+		}
+		added := agent.AddedKey{PrivateKey: key, Comment: keyComment(path)}
+		if err := keyring.Add(added); err != nil {
+			return fmt.Errorf("failed to add key %s: %s", path, err)
+		}
+		if cert := keyCertificate(path); cert != nil {
+			added.Certificate = cert
+			if err := keyring.Add(added); err != nil {
+				return fmt.Errorf("failed to add certificate of key %s: %s", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// keyComment returns the comment of the public key next to the private key
+// at path, or path if there is none.
+func keyComment(path string) string {
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return path
+	}
+	_, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil || comment == "" {
+		return path
+	}
+	return comment
+}
+
+// keyCertificate returns the certificate next to the private key at path,
+// or nil if there is none.
+func keyCertificate(path string) *ssh.Certificate {
+	data, err := os.ReadFile(path + "-cert.pub")
+	if err != nil {
+		return nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil
+	}
+	cert, _ := pub.(*ssh.Certificate)
+	return cert
+}
+
+// serveListener serves a on a unix socket or named pipe.
+func serveListener(address string, a agent.Agent) (func() error, error) {
+	listener, err := pageant.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := pageant.Serve(a, listener); err != nil {
+			log.Printf("pageant-server: %s", err)
+		}
+	}()
+	return listener.Close, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/trzsz/pageant"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serve serves a on address until the returned function is called.
+func serve(address string, a agent.Agent) (func() error, error) {
+	if address != "pageant" {
+		return serveListener(address, a)
+	}
+	server := pageant.NewServer(a)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server.Stop, nil
+}
@@ -0,0 +1,143 @@
+package pageant
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Config is the configuration administrators deploy to preconfigure the
+// tools built on this package, read by LoadConfig. On Windows, it lives in
+// the registry keys SOFTWARE\trzsz\pageant of HKLM and HKCU.
+type Config struct {
+	// Backends is the default of Options.Backends. The value of HKCU
+	// overrides the one of HKLM.
+	Backends []string
+
+	// AllowedBackends restricts the backends NewConnWithOptions uses,
+	// whatever Options.Backends or Backends say; all are allowed if it is
+	// empty. Only backends allowed by both HKLM and HKCU are allowed.
+	AllowedBackends []string
+
+	// AuditLog is the file OpenAudit appends audit records to, and
+	// AuditKeyFile the file holding their HMAC key. The values of HKLM
+	// override the ones of HKCU.
+	AuditLog     string
+	AuditKeyFile string
+
+	// ForbiddenBackends are never used, even when Options.Backends lists
+	// them. Backends forbidden by HKLM, HKCU or the comma separated
+	// PAGEANT_FORBIDDEN_BACKENDS environment variable are all forbidden.
+	ForbiddenBackends []string
+
+	// RequireOwnAgent refuses agents run by other users than the current
+	// one, the system and administrators aside, as the OpenSSH agent
+	// service runs as LocalSystem. It is set by HKLM, HKCU or the
+	// PAGEANT_REQUIRE_OWN_AGENT environment variable set to 1.
+	RequireOwnAgent bool
+
+	// err is why the policy could not be read, refusing every backend.
+	err error
+}
+
+var deployedConfig struct {
+	once   sync.Once
+	config *Config
+}
+
+// systemConfig returns the Config of LoadConfig, read once. A policy that
+// cannot be read refuses every backend, rather than allowing them all.
+func systemConfig() *Config {
+	deployedConfig.once.Do(func() {
+		config, err := LoadConfig()
+		if err != nil {
+			config = &Config{err: fmt.Errorf("cannot read agent policy: %s", err)}
+		}
+		config.applyEnv()
+		deployedConfig.config = config
+	})
+	return deployedConfig.config
+}
+
+// policyError is the error of an agent refused by the policy of Config,
+// which NewConnWithOptions skips to try the next backend.
+type policyError struct {
+	err error
+}
+
+func (e *policyError) Error() string {
+	return e.err.Error()
+}
+
+// applyEnv adds the policy of the environment to c, which can only forbid
+// more than the registry does.
+func (c *Config) applyEnv() {
+	for _, backend := range strings.Split(os.Getenv("PAGEANT_FORBIDDEN_BACKENDS"), ",") {
+		if backend = strings.TrimSpace(backend); backend != "" {
+			c.ForbiddenBackends = append(c.ForbiddenBackends, backend)
+		}
+	}
+	if os.Getenv("PAGEANT_REQUIRE_OWN_AGENT") == "1" {
+		c.RequireOwnAgent = true
+	}
+}
+
+// permits tells whether c allows backend and does not forbid it.
+func (c *Config) permits(backend string) bool {
+	if c.err != nil {
+		return false
+	}
+	for _, forbidden := range c.ForbiddenBackends {
+		if forbidden == backend {
+			return false
+		}
+	}
+	if len(c.AllowedBackends) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedBackends {
+		if allowed == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenAudit returns a, wrapped by an AuditAgent appending to the AuditLog
+// of c if it is set, continuing the chain of its records, which must not
+// have been tampered with. The log stays open for the life of the process.
+// Processes sharing the log, such as pageant-server and pageant-proxy,
+// lock it exclusively to append each record, outside of plan9 and wasip1
+// where each process needs an AuditLog of its own.
+func (c *Config) OpenAudit(a agent.ExtendedAgent) (agent.ExtendedAgent, error) {
+	if c.AuditLog == "" {
+		return a, nil
+	}
+	var key []byte
+	if c.AuditKeyFile != "" {
+		var err error
+		if key, err = os.ReadFile(c.AuditKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read audit key: %s", err)
+		}
+	}
+	log, err := os.OpenFile(c.AuditLog, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	audit := NewAuditAgent(a, log, key)
+	audit.file = &auditFile{File: log}
+	if err := lockFile(log); err != nil {
+		log.Close()
+		return nil, fmt.Errorf("failed to lock audit log: %s", err)
+	}
+	err = audit.catchUp()
+	unlockFile(log)
+	if err != nil {
+		log.Close()
+		return nil, fmt.Errorf("failed to resume audit log: %s", err)
+	}
+	return audit, nil
+}
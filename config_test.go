@@ -0,0 +1,113 @@
+package pageant
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// setConfig makes systemConfig return config until the test ends.
+func setConfig(t *testing.T, config *Config) {
+	systemConfig()
+	saved := deployedConfig.config
+	deployedConfig.config = config
+	t.Cleanup(func() { deployedConfig.config = saved })
+}
+
+func TestConfigBackends(t *testing.T) {
+	setConfig(t, &Config{Backends: []string{BackendPipe, BackendUnix}, AllowedBackends: []string{BackendUnix}})
+	if backends := (&Options{}).backends(); len(backends) != 1 || backends[0] != BackendUnix {
+		t.Fatalf("backends() = %v, want the allowed default backends", backends)
+	}
+	if backends := (&Options{Backends: []string{BackendPageant}}).backends(); len(backends) != 0 {
+		t.Fatalf("backends() = %v, want none allowed", backends)
+	}
+	if _, err := NewConnWithOptions(&Options{Backends: []string{BackendPageant}}); err == nil {
+		t.Fatalf("NewConnWithOptions connected to a forbidden backend")
+	}
+}
+
+func TestConfigOpenAudit(t *testing.T) {
+	// the log stays open, which t.TempDir cannot remove on Windows
+	dir, err := os.MkdirTemp("", "pageant")
+	if err != nil {
+		t.Fatalf("error on MkdirTemp: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	config := &Config{AuditLog: filepath.Join(dir, "audit.log")}
+	// each run of a process appends to the chain of the previous ones
+	for run := 0; run < 2; run++ {
+		a, err := config.OpenAudit(NewProxy(ProxyConfig{}))
+		if err != nil {
+			t.Fatalf("error on OpenAudit: %s", err)
+		}
+		if _, ok := a.(*AuditAgent); !ok {
+			t.Fatalf("OpenAudit returned %T, not an AuditAgent", a)
+		}
+		a.List()
+	}
+	// and processes running at once append to the same chain
+	var shared []agent.ExtendedAgent
+	for i := 0; i < 2; i++ {
+		a, err := config.OpenAudit(NewProxy(ProxyConfig{}))
+		if err != nil {
+			t.Fatalf("error on OpenAudit: %s", err)
+		}
+		shared = append(shared, a)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := shared[i%2].List(); err != nil {
+			t.Fatalf("error on List: %s", err)
+		}
+	}
+	log, err := os.Open(config.AuditLog)
+	if err != nil {
+		t.Fatalf("error on Open: %s", err)
+	}
+	defer log.Close()
+	if last, err := VerifyAuditLog(log, nil); err != nil || last.Seq != 6 {
+		t.Fatalf("VerifyAuditLog of the audit log of 4 processes gave %+v, %v", last, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Setenv("PAGEANT_FORBIDDEN_BACKENDS", "pipe, unix")
+	t.Setenv("PAGEANT_REQUIRE_OWN_AGENT", "1")
+	config := &Config{ForbiddenBackends: []string{BackendPageant}}
+	config.applyEnv()
+	if len(config.ForbiddenBackends) != 3 || !config.RequireOwnAgent {
+		t.Fatalf("applyEnv gave %+v", config)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	opts := &Options{Backends: []string{BackendUnix}, AgentPath: socket}
+
+	setConfig(t, config)
+	if _, err := dialBackend(BackendUnix, opts); err == nil {
+		t.Fatalf("dialBackend connected to a forbidden backend")
+	}
+	if _, err := NewConnWithOptions(opts); err == nil {
+		t.Fatalf("NewConnWithOptions connected to a forbidden backend")
+	}
+
+	setConfig(t, &Config{err: fmt.Errorf("cannot read agent policy")})
+	if _, err := NewConnWithOptions(opts); err == nil || !strings.Contains(err.Error(), "policy") {
+		t.Fatalf("NewConnWithOptions connected without a readable policy: %v", err)
+	}
+
+	setConfig(t, &Config{RequireOwnAgent: true})
+	conn, err := NewConnWithOptions(opts)
+	if err != nil {
+		t.Fatalf("error on NewConnWithOptions to an agent of our own: %s", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,152 @@
+package pageant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// aLongTimeAgo is a deadline in the past, interrupting blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ContextAgent makes requests to the agent on a connection under contexts,
+// which x/crypto's agent client cannot: each request is bounded by the
+// deadline of its context and interrupted when its context is done, through
+// the deadlines of the connection.
+// A request to Pageant, once sent, is only interrupted at its deadline.
+// An interrupted request leaves the connection out of sync, so all later
+// requests fail. It is safe to use ContextAgent in multiple concurrent
+// goroutines, requests being made one at a time.
+type ContextAgent struct {
+	conn   net.Conn
+	client agent.ExtendedAgent
+	mu     sync.Mutex
+	err    error
+}
+
+// NewContextAgent returns a ContextAgent making requests on conn, which
+// must not be used otherwise.
+func NewContextAgent(conn net.Conn) *ContextAgent {
+	return &ContextAgent{conn: conn, client: agent.NewClient(conn)}
+}
+
+// WithContext returns an agent making each request under ctx.
+func (a *ContextAgent) WithContext(ctx context.Context) agent.ExtendedAgent {
+	return contextAgent{agent: a, ctx: ctx}
+}
+
+// do calls f with the agent client while the deadlines of the connection
+// follow ctx.
+func (a *ContextAgent) do(ctx context.Context, f func(agent.ExtendedAgent) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := a.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			a.conn.SetDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	err := f(a.client)
+	close(stop)
+	<-stopped
+
+	// the agent client hides the error of the connection, and the deadline
+	// of the connection may pass before the one of ctx is noticed
+	expired := !deadline.IsZero() && !time.Now().Before(deadline)
+	if err != nil && (ctx.Err() != nil || expired || errors.Is(err, os.ErrDeadlineExceeded)) {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else {
+			err = context.DeadlineExceeded
+		}
+		a.err = fmt.Errorf("agent connection unusable after an interrupted request: %w", err)
+		return err
+	}
+	if e := a.conn.SetDeadline(time.Time{}); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+type contextAgent struct {
+	agent *ContextAgent
+	ctx   context.Context
+}
+
+func (a contextAgent) List() (keys []*agent.Key, err error) {
+	err = a.agent.do(a.ctx, func(c agent.ExtendedAgent) error {
+		keys, err = c.List()
+		return err
+	})
+	return
+}
+
+func (a contextAgent) Sign(key ssh.PublicKey, data []byte) (sig *ssh.Signature, err error) {
+	err = a.agent.do(a.ctx, func(c agent.ExtendedAgent) error {
+		sig, err = c.Sign(key, data)
+		return err
+	})
+	return
+}
+
+func (a contextAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (sig *ssh.Signature, err error) {
+	err = a.agent.do(a.ctx, func(c agent.ExtendedAgent) error {
+		sig, err = c.SignWithFlags(key, data, flags)
+		return err
+	})
+	return
+}
+
+func (a contextAgent) Add(key agent.AddedKey) error {
+	return a.agent.do(a.ctx, func(c agent.ExtendedAgent) error { return c.Add(key) })
+}
+
+func (a contextAgent) Remove(key ssh.PublicKey) error {
+	return a.agent.do(a.ctx, func(c agent.ExtendedAgent) error { return c.Remove(key) })
+}
+
+func (a contextAgent) RemoveAll() error {
+	return a.agent.do(a.ctx, func(c agent.ExtendedAgent) error { return c.RemoveAll() })
+}
+
+func (a contextAgent) Lock(passphrase []byte) error {
+	return a.agent.do(a.ctx, func(c agent.ExtendedAgent) error { return c.Lock(passphrase) })
+}
+
+func (a contextAgent) Unlock(passphrase []byte) error {
+	return a.agent.do(a.ctx, func(c agent.ExtendedAgent) error { return c.Unlock(passphrase) })
+}
+
+func (a contextAgent) Signers() ([]ssh.Signer, error) {
+	return agentSigners(a)
+}
+
+func (a contextAgent) Extension(extensionType string, contents []byte) (response []byte, err error) {
+	err = a.agent.do(a.ctx, func(c agent.ExtendedAgent) error {
+		response, err = c.Extension(extensionType, contents)
+		return err
+	})
+	return
+}
@@ -0,0 +1,58 @@
+package pageant
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestContextAgent(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go agent.ServeAgent(agent.NewKeyring(), server)
+
+	a := NewContextAgent(client)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := a.WithContext(ctx).List(); err != nil {
+		t.Fatalf("error on List: %s", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.WithContext(canceled).List(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("List with a canceled context returned %v", err)
+	}
+	if _, err := a.WithContext(context.Background()).List(); err != nil {
+		t.Fatalf("error on List after a canceled context: %s", err)
+	}
+}
+
+func TestContextAgentInterrupt(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	// an agent which never answers
+	go io.Copy(io.Discard, server)
+
+	a := NewContextAgent(client)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := a.WithContext(ctx).List(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("List past the deadline returned %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	b := NewContextAgent(client)
+	if _, err := b.WithContext(ctx).List(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("List canceled while waiting returned %v", err)
+	}
+	if _, err := b.WithContext(context.Background()).List(); err == nil {
+		t.Fatalf("List succeeded on an interrupted connection")
+	}
+}
@@ -0,0 +1,114 @@
+package pageant
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentReport is what Diagnose found out about the agent of one backend.
+type AgentReport struct {
+	Backend string      `json:"backend"`
+	Found   bool        `json:"found"`
+	Keys    []KeyReport `json:"keys,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Hints   []string    `json:"hints,omitempty"`
+}
+
+// KeyReport describes a key held by an agent.
+type KeyReport struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// Diagnose tries the agent of every backend available on this platform,
+// lists its keys and suggests how to fix the problems met on the way.
+func Diagnose() []AgentReport {
+	backends := []string{BackendUnix}
+	if runtime.GOOS == "windows" {
+		backends = []string{BackendPageant, BackendPipe, BackendUnix}
+	}
+	reports := make([]AgentReport, 0, len(backends))
+	for _, backend := range backends {
+		reports = append(reports, diagnoseBackend(backend))
+	}
+	return reports
+}
+
+// diagnoseBackend fills the report of backend.
+func diagnoseBackend(backend string) AgentReport {
+	report := AgentReport{Backend: backend}
+	fail := func(err error) AgentReport {
+		report.Error = err.Error()
+		report.Hints = append(report.Hints, diagnoseHints(backend, err)...)
+		return report
+	}
+
+	conn, err := dialBackend(backend, &Options{PipeDialTimeout: availableTimeout})
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	report.Found = true
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return fail(err)
+	}
+	for _, key := range keys {
+		keyReport := KeyReport{Type: key.Format, Comment: key.Comment}
+		if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+			keyReport.Fingerprint = ssh.FingerprintSHA256(pub)
+		}
+		report.Keys = append(report.Keys, keyReport)
+	}
+	if len(keys) == 0 {
+		if backend == BackendPageant {
+			report.Hints = append(report.Hints, Text(MessageHintPageantNoKeys))
+		} else {
+			report.Hints = append(report.Hints, Text(MessageHintAgentNoKeys))
+		}
+	}
+	return report
+}
+
+// diagnoseHints suggests how to fix err met with the agent of backend.
+func diagnoseHints(backend string, err error) []string {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	switch backend {
+	case BackendPageant:
+		if !PageantAvailable() {
+			return []string{Text(MessageHintStartPageant)}
+		}
+		if strings.Contains(err.Error(), "refused") && elevated() {
+			return []string{Text(MessageHintElevation)}
+		}
+	case BackendPipe:
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{Text(MessageHintStartPipeAgent)}
+		}
+		if errors.Is(err, os.ErrPermission) {
+			return []string{Text(MessageHintPipeOtherUser)}
+		}
+	case BackendUnix:
+		if socket == "" {
+			return []string{Text(MessageHintNoSocket)}
+		}
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOENT) {
+			return []string{Text(MessageHintMissingSocket)}
+		}
+		if isStaleSocket(err) {
+			return []string{Text(MessageHintStaleSocket, socket)}
+		}
+		if errors.Is(err, os.ErrPermission) {
+			return []string{Text(MessageHintSocketOtherUser)}
+		}
+	}
+	return nil
+}
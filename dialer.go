@@ -0,0 +1,89 @@
+package pageant
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"time"
+)
+
+// Dialer network names, as accepted by Dialer.
+const (
+	// NetworkPageant connects to Pageant only.
+	NetworkPageant = "pageant"
+	// NetworkSSHAgent connects to the first agent found, as
+	// NewConnWithOptions does.
+	NetworkSSHAgent = "ssh-agent"
+)
+
+// Dialer connects to agents for code accepting a generic dialer, such as
+// the Dialer and ContextDialer of golang.org/x/net/proxy.
+// It dials the networks NetworkPageant and NetworkSSHAgent, where a non
+// empty address names the named pipe or unix socket of the agent in place
+// of Options.AgentPath or SSH_AUTH_SOCK, and of Options.Backends.
+type Dialer struct {
+	// Options tunes the connections, nil meaning the defaults.
+	Options *Options
+}
+
+// Dial connects to the agent of network at address.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the agent of network at address, giving up once
+// ctx is done. The deadline of ctx also bounds waiting for a busy named pipe.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	opts := Options{}
+	if d.Options != nil {
+		opts = *d.Options
+	}
+	switch network {
+	case NetworkPageant:
+		opts.Backends = []string{BackendPageant}
+	case NetworkSSHAgent:
+		if address != "" {
+			// a running Pageant must not take the place of the agent named
+			opts.AgentPath = address
+			opts.Backends = []string{BackendUnix}
+			if runtime.GOOS == "windows" {
+				opts.Backends = []string{BackendPipe}
+			}
+		}
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := opts.PipeDialTimeout
+		if timeout <= 0 {
+			timeout = DefaultPipeDialTimeout
+		}
+		if left := time.Until(deadline); left < timeout {
+			opts.PipeDialTimeout = left
+		}
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := NewConnWithOptions(&opts)
+		done <- dialResult{conn, err}
+	}()
+	select {
+	case result := <-done:
+		return result.conn, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-done; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package pageant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestDialer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ssh-agent addresses are named pipes on Windows")
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	go Serve(agent.NewKeyring(), listener)
+
+	// the address takes the place of the backends of Options
+	dialer := &Dialer{Options: &Options{Backends: []string{BackendPageant}}}
+	conn, err := dialer.Dial(NetworkSSHAgent, socket)
+	if err != nil {
+		t.Fatalf("error on Dial: %s", err)
+	}
+	defer conn.Close()
+	if _, err := agent.NewClient(conn).List(); err != nil {
+		t.Fatalf("error on List: %s", err)
+	}
+
+	var unknown net.UnknownNetworkError
+	if _, err := dialer.Dial("tcp", socket); !errors.As(err, &unknown) {
+		t.Fatalf("Dial of an unknown network returned %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dialer.DialContext(ctx, NetworkSSHAgent, socket); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DialContext with a canceled context returned %v", err)
+	}
+}
@@ -6,21 +6,63 @@ package pageant
 import (
 	"fmt"
 	"net"
-	"os"
+	"time"
 )
 
 // NewConn creates a new connection to Pageant or agent.
 // Ensure Close gets called on the returned Conn when it is no longer needed.
 func NewConn() (net.Conn, error) {
-	const sshAuthSock = "SSH_AUTH_SOCK"
-	socket := os.Getenv(sshAuthSock)
-	if socket == "" {
-		return nil, fmt.Errorf("empty %s", sshAuthSock)
-	}
-	return net.Dial("unix", socket)
+	return NewConnWithOptions(nil)
 }
 
 // used in establishConn
 func PageantWindow() (window uintptr, err error) {
 	return 0, fmt.Errorf("cannot find Pageant window, ensure Pageant is running and runtime.GOOS==`windows`")
 }
+
+// isPageantConn tells whether conn uses the shared memory transport.
+func isPageantConn(_ net.Conn) bool {
+	return false
+}
+
+// pageantConnMsglen fails as there are no Pageant connections outside of
+// Windows.
+func pageantConnMsglen(_ net.Conn) (int, error) {
+	return 0, fmt.Errorf("Pageant is only available on Windows")
+}
+
+// PageantAvailable returns pageant available or not.
+func PageantAvailable() bool {
+	return false
+}
+
+// dialPageant fails as there is no Pageant window outside of Windows.
+func dialPageant(_ *Options) (net.Conn, error) {
+	return nil, fmt.Errorf("Pageant is only available on Windows")
+}
+
+// dialNamedPipe fails as there are no named pipe agents outside of Windows.
+func dialNamedPipe(_ *Options) (net.Conn, error) {
+	return nil, fmt.Errorf("named pipe agents are only available on Windows")
+}
+
+// elevated tells whether the process runs elevated by UAC.
+func elevated() bool {
+	return false
+}
+
+// pipeAvailable tells whether a named pipe agent accepts connections.
+func pipeAvailable(_ time.Duration) bool {
+	return false
+}
+
+// listenPipe fails as there are no named pipes outside of Windows.
+func listenPipe(_ string) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipe agents are only available on Windows")
+}
+
+// LoadConfig returns an empty Config, as configuration is only deployed in
+// the registry on Windows.
+func LoadConfig() (*Config, error) {
+	return &Config{}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9 && !wasip1
+// +build !windows,!plan9,!wasip1
+
+package pageant
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile waits for an exclusive lock on file, held against other
+// processes until unlockFile.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases the lock of lockFile.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -6,4 +6,5 @@ require (
 	github.com/Microsoft/go-winio v0.6.2
 	golang.org/x/crypto v0.19.0
 	golang.org/x/sys v0.20.0
+	golang.org/x/term v0.17.0
 )
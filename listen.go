@@ -0,0 +1,47 @@
+package pageant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Listen announces an agent at address, either "unix:PATH" or, on
+// Windows, "pipe:PATH", where only the current user may connect.
+func Listen(address string) (net.Listener, error) {
+	network, path, ok := strings.Cut(address, ":")
+	if !ok || path == "" {
+		return nil, fmt.Errorf("agent address %q is not unix:PATH or pipe:PATH", address)
+	}
+	switch network {
+	case "unix":
+		return listenUnix(path)
+	case "pipe":
+		return listenPipe(path)
+	}
+	return nil, fmt.Errorf("unknown agent network %q", network)
+}
+
+// Serve serves a on each connection accepted by listener, until listener
+// is closed. A StatsAgent records the peer of each connection where known.
+func Serve(a agent.Agent, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		served := a
+		if stats, ok := a.(*StatsAgent); ok {
+			served = stats.WithPeer(connPeer(conn))
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(served, conn)
+		}()
+	}
+}
@@ -0,0 +1,69 @@
+package pageant
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Message is a user facing text of this package and of its commands: its
+// English format for fmt.Sprintf, which identifies it to Translator.
+type Message string
+
+// Messages of confirmation prompts and notifications.
+const (
+	// MessageConfirmKey asks whether a key may be used, with the comment
+	// and the fingerprint of the key as arguments.
+	MessageConfirmKey Message = "Allow use of key %s?\nKey fingerprint %s."
+	// MessageIdleLocked tells that the agent locked itself, with how long
+	// it was idle as argument.
+	MessageIdleLocked Message = "The agent was locked after %s idle."
+)
+
+// Messages of the hints of Diagnose.
+const (
+	MessageHintPageantNoKeys   Message = "Pageant holds no keys: add one from its tray icon or run pageant.exe with a .ppk file"
+	MessageHintAgentNoKeys     Message = "the agent holds no keys: add one with ssh-add"
+	MessageHintStartPageant    Message = "start Pageant, or another Pageant compatible agent such as KeeAgent"
+	MessageHintElevation       Message = "this process is elevated, Pageant probably is not: run both elevated or both unelevated"
+	MessageHintStartPipeAgent  Message = "start the OpenSSH agent service: Start-Service ssh-agent, or set SSH_AUTH_SOCK to the pipe of your agent"
+	MessageHintPipeOtherUser   Message = "the agent pipe belongs to another user or to an elevated process: run as the user owning the agent"
+	MessageHintNoSocket        Message = "SSH_AUTH_SOCK is not set: start an agent with eval $(ssh-agent)"
+	MessageHintMissingSocket   Message = "SSH_AUTH_SOCK names a socket which does not exist: the agent has exited, start it again and update SSH_AUTH_SOCK"
+	MessageHintStaleSocket     Message = "nothing listens on SSH_AUTH_SOCK, it is a stale socket: remove %s and start the agent again"
+	MessageHintSocketOtherUser Message = "SSH_AUTH_SOCK belongs to another user: use an agent of your own"
+)
+
+// Translator returns the format of message in the language of the user,
+// taking the same arguments, or "" to keep the English one.
+type Translator func(message Message) string
+
+var messages struct {
+	mu         sync.RWMutex
+	translator Translator
+}
+
+// SetTranslator makes Text translate messages with translator; nil
+// restores English.
+func SetTranslator(translator Translator) {
+	messages.mu.Lock()
+	defer messages.mu.Unlock()
+	messages.translator = translator
+}
+
+// Text returns message formatted with args, translated by the Translator
+// of SetTranslator.
+func Text(message Message, args ...interface{}) string {
+	messages.mu.RLock()
+	translator := messages.translator
+	messages.mu.RUnlock()
+	format := string(message)
+	if translator != nil {
+		if translated := translator(message); translated != "" {
+			format = translated
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
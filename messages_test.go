@@ -0,0 +1,22 @@
+package pageant
+
+import "testing"
+
+func TestText(t *testing.T) {
+	defer SetTranslator(nil)
+	if text := Text(MessageConfirmKey, "work", "SHA256:x"); text != "Allow use of key work?\nKey fingerprint SHA256:x." {
+		t.Fatalf("English text is %q", text)
+	}
+	SetTranslator(func(message Message) string {
+		if message == MessageConfirmKey {
+			return "Utiliser la clé %s ?\nEmpreinte %s."
+		}
+		return ""
+	})
+	if text := Text(MessageConfirmKey, "work", "SHA256:x"); text != "Utiliser la clé work ?\nEmpreinte SHA256:x." {
+		t.Fatalf("translated text is %q", text)
+	}
+	if text := Text(MessageHintAgentNoKeys); text != string(MessageHintAgentNoKeys) {
+		t.Fatalf("untranslated text is %q", text)
+	}
+}
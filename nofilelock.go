@@ -0,0 +1,16 @@
+//go:build plan9 || wasip1
+// +build plan9 wasip1
+
+package pageant
+
+import "os"
+
+// lockFile does nothing, as files cannot be locked on this platform.
+func lockFile(_ *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing, as lockFile does not lock.
+func unlockFile(_ *os.File) error {
+	return nil
+}
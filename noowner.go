@@ -0,0 +1,14 @@
+//go:build plan9 || wasip1
+// +build plan9 wasip1
+
+package pageant
+
+import (
+	"fmt"
+)
+
+// verifySocketOwner fails, as the owner of files is unknown on this
+// platform.
+func verifySocketOwner(path string) error {
+	return fmt.Errorf("cannot verify the owner of agent socket %s on this platform", path)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package pageant
+
+import "net"
+
+// connPeer names the client of conn, which is only known on Linux.
+func connPeer(conn net.Conn) string {
+	return ""
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9 || wasip1
+// +build windows plan9 wasip1
+
+package pageant
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix listens on a unix socket at path. File modes do not guard
+// sockets on this platform, so it is made 0600 only after it is created.
+func listenUnix(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,10 @@
+//go:build plan9
+// +build plan9
+
+package pageant
+
+// isStaleSocket tells whether err is the refusal of a unix socket no agent
+// listens on anymore, which plan9 has no unix sockets for.
+func isStaleSocket(err error) bool {
+	return false
+}
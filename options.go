@@ -0,0 +1,173 @@
+package pageant
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPipeDialTimeout is how long connecting waits for a busy named
+	// pipe agent unless Options.PipeDialTimeout is set.
+	DefaultPipeDialTimeout = 5 * time.Second
+	// DefaultReadBufferSize is a size for Options.ReadBufferSize large
+	// enough for an identity list holding many certificates in one read.
+	DefaultReadBufferSize = 64 * 1024
+	// DefaultPipePrefix is prepended to bare named pipe names, such as
+	// openssh-ssh-agent, unless Options.PipePrefix is set.
+	DefaultPipePrefix = `\\.\pipe\`
+)
+
+// ErrResponsePending is returned by Write with BackpressureError while the
+// previous response has not been fully read and Options.ResponseQueue is
+// full.
+var ErrResponsePending = errors.New("previous response from Pageant has not been read")
+
+// Backpressure selects what Write on a Pageant connection does while the
+// previous response has not been fully read and Options.ResponseQueue is
+// full.
+type Backpressure int
+
+const (
+	// BackpressureDiscard drops the unread responses, queued ones included,
+	// as Pageant connections always did.
+	BackpressureDiscard Backpressure = iota
+	// BackpressureBlock waits until another goroutine reads a response or
+	// closes the connection.
+	BackpressureBlock
+	// BackpressureError fails with ErrResponsePending.
+	BackpressureError
+)
+
+// The Authenticode signers accepted when Options.VerifyPublisher is set and
+// Options.AllowedPublishers is empty.
+var (
+	// DefaultPageantPublishers sign the process of the Pageant window:
+	// PuTTY's Pageant only, as any inbox Windows program, such as a script
+	// host, can create a window named Pageant.
+	DefaultPageantPublishers = []string{"Simon Tatham"}
+	// DefaultPipePublishers sign the server of named pipe agents: PuTTY's
+	// Pageant, Win32-OpenSSH releases and the inbox OpenSSH of Windows.
+	DefaultPipePublishers = []string{"Simon Tatham", "Microsoft Corporation", "Microsoft Windows"}
+)
+
+// Options tunes how NewConnWithOptions connects to an agent.
+// A nil *Options behaves exactly like NewConn.
+type Options struct {
+	// Backends lists the Backend names tried in order by NewConnWithOptions.
+	// It defaults to Pageant then the named pipe agent on Windows, and to
+	// the unix socket agent elsewhere.
+	Backends []string
+
+	// AgentPath overrides SSH_AUTH_SOCK as the path of the named pipe or
+	// unix socket agent. For the named pipe agent, both accept full pipe
+	// paths, with backslashes or slashes, npipe: prefixed paths as Docker
+	// uses them, bare pipe names and filesystem paths of unix sockets.
+	AgentPath string
+
+	// PipePrefix overrides DefaultPipePrefix, such as \\server\pipe\ for
+	// the pipes of another machine.
+	PipePrefix string
+
+	// VerifyPublisher refuses Pageant or named pipe agents whose executable
+	// has no valid Authenticode signature from one of AllowedPublishers.
+	// It is only supported on Windows.
+	VerifyPublisher bool
+
+	// AllowedPublishers overrides DefaultPageantPublishers and
+	// DefaultPipePublishers.
+	AllowedPublishers []string
+
+	// VerifyPipeServer refuses named pipe agents, before any request is sent,
+	// unless the pipe is owned by the current user, LocalSystem or the
+	// Administrators group and, if AllowedPipeServers is not empty, is
+	// served by one of its executables.
+	// It is only supported on Windows.
+	VerifyPipeServer bool
+
+	// AllowedPipeServers lists the full paths of accepted named pipe agent
+	// executables, such as `C:\Windows\System32\OpenSSH\ssh-agent.exe`.
+	AllowedPipeServers []string
+
+	// CompatibleMapName names the shared memory of Pageant requests after
+	// the thread id, as PuTTY did, instead of using a random name.
+	// Only enable it for Pageant emulators that depend on that format.
+	CompatibleMapName bool
+
+	// Backpressure applies to Pageant connections written again before the
+	// previous response was read, typically by proxies with slow clients,
+	// once ResponseQueue is full.
+	Backpressure Backpressure
+
+	// ResponseQueue is how many unread responses a Pageant connection keeps,
+	// to be read in order, when it is written again before the previous
+	// response was read. The queue is full once it holds ResponseQueue
+	// responses or MaxPendingBytes bytes. It defaults to 0, leaving the last
+	// response only, so that with BackpressureBlock requests and responses
+	// alternate synchronously.
+	ResponseQueue int
+
+	// MaxPendingBytes caps the size of the responses held in the
+	// ResponseQueue of a Pageant connection. The response in the shared
+	// memory is not counted, as Pageant has carried out its request already.
+	// It defaults to room for ResponseQueue responses of the longest message
+	// of the Pageant connected, 8192 bytes before Pageant 0.75.
+	MaxPendingBytes int
+
+	// SendTimeout bounds how long a request waits for Pageant to answer,
+	// like a write deadline renewed for each request. Without it, and
+	// without a deadline, a request waits for as long as Pageant takes.
+	SendTimeout time.Duration
+
+	// PipeDialTimeout overrides DefaultPipeDialTimeout.
+	PipeDialTimeout time.Duration
+
+	// PipeMessageMode reads named pipe agents in message mode, for agents
+	// creating message pipes that expect their clients to do so.
+	PipeMessageMode bool
+
+	// ReadBufferSize reads named pipe agents through a buffer of this size,
+	// such as DefaultReadBufferSize, if positive. Otherwise they are read
+	// unbuffered, through the connection of winio itself.
+	ReadBufferSize int
+}
+
+// backpressure returns the Backpressure of o, which may be nil.
+func (o *Options) backpressure() Backpressure {
+	if o == nil {
+		return BackpressureDiscard
+	}
+	return o.Backpressure
+}
+
+// responseQueue returns the ResponseQueue of o, which may be nil.
+func (o *Options) responseQueue() int {
+	if o == nil || o.ResponseQueue < 0 {
+		return 0
+	}
+	return o.ResponseQueue
+}
+
+// pipePrefix returns the prefix of bare named pipe names.
+func (o *Options) pipePrefix() string {
+	prefix := o.PipePrefix
+	if prefix == "" {
+		return DefaultPipePrefix
+	}
+	if !strings.HasSuffix(prefix, `\`) {
+		prefix += `\`
+	}
+	return prefix
+}
+
+// publishers returns the signer names accepted by VerifyPublisher for the
+// Pageant window, or for named pipe agents unless pageant is set.
+func (o *Options) publishers(pageant bool) []string {
+	if len(o.AllowedPublishers) > 0 {
+		return o.AllowedPublishers
+	}
+	if pageant {
+		return DefaultPageantPublishers
+	}
+	return DefaultPipePublishers
+}
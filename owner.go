@@ -0,0 +1,27 @@
+//go:build !windows && !plan9 && !wasip1
+// +build !windows,!plan9,!wasip1
+
+package pageant
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// verifySocketOwner checks that the unix socket agent at path belongs to
+// the current user or to root.
+func verifySocketOwner(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot get owner of agent socket %s", path)
+	}
+	if int(stat.Uid) != os.Getuid() && stat.Uid != 0 {
+		return fmt.Errorf("agent socket %s is owned by untrusted uid %d", path, stat.Uid)
+	}
+	return nil
+}
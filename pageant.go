@@ -8,6 +8,7 @@
 package pageant
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -16,62 +17,129 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
 
-	"github.com/Microsoft/go-winio"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/sys/windows"
 )
 
 const (
 	agentCopydataID = 0x804e50ba
-	agentMaxMsglen  = 8192
 	noError         = syscall.Errno(0)
 	wmCopyData      = 0x004a
+	smtoErrorOnExit = 0x0020
+
+	// pageantWindowTTL is how long PageantWindow reuses the result of
+	// FindWindow.
+	pageantWindowTTL = time.Second
 )
 
 var (
-	pageantWindowName = utf16Ptr("Pageant")
-	user32            = windows.NewLazySystemDLL("user32.dll")
-	findWindow        = user32.NewProc("FindWindowW")
-	sendMessage       = user32.NewProc("SendMessageW")
+	pageantWindowName  = utf16Ptr("Pageant")
+	user32             = windows.NewLazySystemDLL("user32.dll")
+	findWindow         = user32.NewProc("FindWindowW")
+	sendMessage        = user32.NewProc("SendMessageW")
+	sendMessageTimeout = user32.NewProc("SendMessageTimeoutW")
+
+	pageantWindowCache struct {
+		sync.Mutex
+		window  uintptr
+		err     error
+		expires time.Time
+	}
+
+	// pageantMsglenCache is the longest message the Pageant of window
+	// accepts, probed once per window.
+	pageantMsglenCache struct {
+		sync.Mutex
+		window uintptr
+		msglen int
+	}
 )
 
 // Conn is a shared-memory connection to Pageant.
 // Conn implements net.Reader, net.Writer, and net.Closer.
-// It is not safe to use Conn in multiple concurrent goroutines.
+// It is not safe to use Conn in multiple concurrent goroutines, except for
+// a reader unblocking a writer that waits with BackpressureBlock.
+// Unread responses are kept in Options.ResponseQueue, then dropped or
+// waited for as set by Options.Backpressure.
 type Conn struct {
 	window     windows.Handle
 	sharedFile windows.Handle
 	sharedMem  uintptr
 	readOffset int
 	readLimit  int
+	queue      [][]byte
+	queued     int
 	mapName    string
+	msglen     int
+	opts       *Options
+	verified   windows.Handle
+	cond       *sync.Cond
+	closes     int
+	// writeDeadline is in Unix nanoseconds, 0 for none, and is atomic so
+	// that it can be set while Write waits for Pageant.
+	writeDeadline atomic.Int64
 	sync.Mutex
 }
 
 // NewConn creates a new connection to Pageant or to ssh-agent.exe of OpenSSH_for_Windows
 // Ensure Close gets called on the returned Conn when it is no longer needed.
 func NewConn() (net.Conn, error) {
+	return NewConnWithOptions(nil)
+}
+
+// dialPageant connects to Pageant through its window.
+func dialPageant(opts *Options) (net.Conn, error) {
+	window, err := PageantWindow()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{opts: opts}
+	if err := c.verifyWindow(window); err != nil {
+		return nil, err
+	}
+	if systemConfig().RequireOwnAgent {
+		if err := verifyWindowOwner(window); err != nil {
+			return nil, &policyError{err}
+		}
+	}
+	return c, nil
+}
+
+// dialNamedPipe connects to the named pipe agent of SSH_AUTH_SOCK, or to
+// the unix socket it names with a filesystem path.
+func dialNamedPipe(opts *Options) (net.Conn, error) {
+	path, socket := pipePath(opts)
+	if socket {
+		return dialSocket(path)
+	}
+	return dialPipe(path, opts)
+}
+
+// elevated tells whether the process runs elevated by UAC.
+func elevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// pipePath returns the path of the named pipe agent from
+// Options.AgentPath or SSH_AUTH_SOCK, and whether it is a unix socket.
+func pipePath(opts *Options) (string, bool) {
 	const (
-		PIPE        = `\\.\pipe\`
 		sshAuthPipe = "openssh-ssh-agent"
 		sshAuthSock = "SSH_AUTH_SOCK"
 	)
-	_, err := PageantWindow()
-	if err == nil {
-		return &Conn{}, nil
-	}
-
-	sockPath := os.Getenv("SSH_AUTH_SOCK")
+	sockPath := opts.AgentPath
 	if sockPath == "" {
-		sockPath = sshAuthPipe
+		sockPath = os.Getenv(sshAuthSock)
 	}
-	if !strings.HasPrefix(sockPath, PIPE) {
-		sockPath = PIPE + sockPath
+	if strings.TrimSpace(sockPath) == "" {
+		sockPath = sshAuthPipe
 	}
-	return winio.DialPipe(sockPath, nil)
+	return normalizePipePath(sockPath, opts.pipePrefix())
 }
 
 // PageantAvailable returns pageant available or not.
@@ -87,7 +155,13 @@ func NewPageantConn() (net.Conn, error) {
 	if !PageantAvailable() {
 		return nil, fmt.Errorf("pageant is not available")
 	}
-	return &Conn{}, nil
+	return &Conn{opts: &Options{}}, nil
+}
+
+// isPageantConn tells whether conn uses the shared memory transport.
+func isPageantConn(conn net.Conn) bool {
+	_, ok := conn.(*Conn)
+	return ok
 }
 
 // for net.Conn
@@ -97,25 +171,47 @@ func (c *Conn) LocalAddr() net.Addr {
 func (c *Conn) RemoteAddr() net.Addr {
 	return nil
 }
-func (c *Conn) SetDeadline(_ time.Time) error {
-	return nil
+
+// SetDeadline sets the write deadline, as reads never wait.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetWriteDeadline(t)
 }
 func (c *Conn) SetReadDeadline(_ time.Time) error {
 	return nil
 }
-func (c *Conn) SetWriteDeadline(_ time.Time) error {
+
+// SetWriteDeadline bounds how long Write waits for Pageant to answer.
+// Write fails with os.ErrDeadlineExceeded once it is exceeded.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.writeDeadline.Store(0)
+	} else {
+		c.writeDeadline.Store(t.UnixNano())
+	}
 	return nil
 }
 
-// Close frees resources used by Conn.
+// Close frees resources used by Conn and drops any unread response.
+// A Write blocked by BackpressureBlock fails with net.ErrClosed.
 func (c *Conn) Close() error {
-	if c.sharedMem == 0 {
-		return nil
-	}
-
 	c.Lock()
 	defer c.Unlock()
 
+	c.readOffset = 0
+	c.readLimit = 0
+	c.queue = nil
+	c.queued = 0
+	c.closes++
+	c.broadcast()
+	return c.release()
+}
+
+// release unmaps the shared memory of the previous request.
+// The caller must hold the lock.
+func (c *Conn) release() error {
+	if c.sharedMem == 0 {
+		return nil
+	}
 	errUnmap := windows.UnmapViewOfFile(c.sharedMem)
 	errClose := windows.CloseHandle(c.sharedFile)
 	if errUnmap != nil {
@@ -129,6 +225,19 @@ func (c *Conn) Close() error {
 }
 
 func (c *Conn) Read(p []byte) (n int, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.queue) > 0 {
+		n = copy(p, c.queue[0])
+		c.queue[0] = c.queue[0][n:]
+		c.queued -= n
+		if len(c.queue[0]) == 0 {
+			c.queue = c.queue[1:]
+			c.broadcast()
+		}
+		return n, nil
+	}
 	if c.sharedMem == 0 {
 		return 0, fmt.Errorf("not connected to Pageant")
 	} else if c.readLimit == 0 {
@@ -137,43 +246,64 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 		return 0, io.EOF
 	}
 
-	c.Lock()
-	defer c.Unlock()
-
 	bytesToRead := minInt(len(p), c.readLimit-c.readOffset)
 	src := toSlice(c.sharedMem+uintptr(c.readOffset), bytesToRead)
 	copy(p, src)
 	c.readOffset += bytesToRead
+	if c.readOffset == c.readLimit {
+		c.broadcast()
+	}
 	return bytesToRead, nil
 }
 
 // close, establishConn, sendMessage
 func (c *Conn) Write(p []byte) (n int, err error) {
-	if len(p) > agentMaxMsglen {
-		return 0, fmt.Errorf("size of request message (%d) exceeds max length (%d)", len(p), agentMaxMsglen)
+	if len(p) > pageantLargeMsglen {
+		return 0, fmt.Errorf("size of request message (%d) exceeds max length (%d)", len(p), pageantLargeMsglen)
 	} else if len(p) == 0 {
 		return 0, fmt.Errorf("message to send is empty")
 	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.waitUnread(); err != nil {
+		return 0, err
+	}
 	if c.sharedMem != 0 {
-		err := c.Close()
+		err := c.release()
 		if c.sharedMem != 0 {
 			return 0, fmt.Errorf("failed to close previous connection: %s", err)
 		}
 	}
+	c.readOffset = 0
+	c.readLimit = 0
 
-	if err := c.establishConn(); err != nil {
+	msglen, err := c.pageantMsglen()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to Pageant: %s", err)
+	}
+	if len(p) > msglen {
+		return 0, fmt.Errorf("size of request message (%d) exceeds max length (%d) of this Pageant", len(p), msglen)
+	}
+	if err := c.establishConn(msglen); err != nil {
 		return 0, fmt.Errorf("failed to connect to Pageant: %s", err)
 	}
-
-	c.Lock()
-	defer c.Unlock()
 
 	dst := toSlice(c.sharedMem, len(p))
 	copy(dst, p)
 	data := make([]byte, len(c.mapName)+1)
 	copy(data, c.mapName)
-	result, err := c.sendMessage(data)
+	timeout, err := c.sendTimeout()
+	if err != nil {
+		return 0, err
+	}
+	result, err := c.sendMessage(data, timeout)
+	if err == os.ErrDeadlineExceeded {
+		return 0, err
+	}
 	if result == 0 {
+		invalidatePageantWindow()
 		if err != nil {
 			return 0, fmt.Errorf("failed to send request to Pageant: %s", err)
 		} else {
@@ -181,16 +311,75 @@ func (c *Conn) Write(p []byte) (n int, err error) {
 		}
 	}
 	messageSize := binary.BigEndian.Uint32(toSlice(c.sharedMem, 4))
-	if messageSize > agentMaxMsglen-4 {
-		return 0, fmt.Errorf("size of response message (%d) exceeds max length (%d)", messageSize+4, agentMaxMsglen)
+	if int(messageSize) > c.msglen-4 {
+		return 0, fmt.Errorf("size of response message (%d) exceeds max length (%d)", messageSize+4, c.msglen)
 	}
 	c.readOffset = 0
 	c.readLimit = 4 + int(messageSize)
 	return len(p), nil
 }
 
+// waitUnread queues the previous response if it has not been fully read,
+// applying Options.Backpressure while Options.ResponseQueue is full.
+// The caller must hold the lock.
+func (c *Conn) waitUnread() error {
+	closes := c.closes
+	for c.readOffset < c.readLimit && !c.enqueue() {
+		switch c.opts.backpressure() {
+		case BackpressureError:
+			return ErrResponsePending
+		case BackpressureBlock:
+			if c.cond == nil {
+				c.cond = sync.NewCond(&c.Mutex)
+			}
+			c.cond.Wait()
+			if c.closes != closes {
+				return net.ErrClosed
+			}
+		default:
+			// the reader must not be served the responses before the dropped one
+			c.queue = nil
+			c.queued = 0
+			return nil
+		}
+	}
+	return nil
+}
+
+// enqueue copies the unread part of the response in the shared memory to
+// the queue, if Options.ResponseQueue has room for it.
+// The caller must hold the lock.
+func (c *Conn) enqueue() bool {
+	size := c.readLimit - c.readOffset
+	if len(c.queue) >= c.opts.responseQueue() || c.queued+size > c.opts.maxPendingBytes(c.msglen) {
+		return false
+	}
+	response := make([]byte, size)
+	copy(response, toSlice(c.sharedMem+uintptr(c.readOffset), size))
+	c.queue = append(c.queue, response)
+	c.queued += size
+	c.readOffset = c.readLimit
+	return true
+}
+
+// broadcast wakes up writers waiting in waitUnread.
+// The caller must hold the lock.
+func (c *Conn) broadcast() {
+	if c.cond != nil {
+		c.cond.Broadcast()
+	}
+}
+
 // used in establishConn and NewConn
+// The result, found or not, is cached for pageantWindowTTL, so polling it
+// is cheap.
 func PageantWindow() (window uintptr, err error) {
+	pageantWindowCache.Lock()
+	defer pageantWindowCache.Unlock()
+	if time.Now().Before(pageantWindowCache.expires) {
+		return pageantWindowCache.window, pageantWindowCache.err
+	}
+
 	window, _, err = findWindow.Call(
 		uintptr(unsafe.Pointer(pageantWindowName)),
 		uintptr(unsafe.Pointer(pageantWindowName)),
@@ -204,24 +393,45 @@ func PageantWindow() (window uintptr, err error) {
 	} else {
 		err = nil
 	}
+	pageantWindowCache.window = window
+	pageantWindowCache.err = err
+	pageantWindowCache.expires = time.Now().Add(pageantWindowTTL)
 	return
 }
 
+// invalidatePageantWindow makes the next PageantWindow call FindWindow again,
+// for when the cached window may have gone away.
+func invalidatePageantWindow() {
+	pageantWindowCache.Lock()
+	defer pageantWindowCache.Unlock()
+	pageantWindowCache.expires = time.Time{}
+}
+
 // establishConn creates a new connection to Pageant.
-func (c *Conn) establishConn() error {
+func (c *Conn) establishConn(msglen int) error {
 	window, err := PageantWindow()
 	if err != nil {
 		return err
 	}
+	if err := c.verifyWindow(window); err != nil {
+		return err
+	}
 
-	mapName := fmt.Sprintf("PageantRequest%08x", windows.GetCurrentThreadId())
+	mapName, err := c.newMapName()
+	if err != nil {
+		return err
+	}
 	mapNameUTF16 := utf16Ptr(mapName)
+	sa, err := mappingSecurity()
+	if err != nil {
+		return fmt.Errorf("failed to secure shared file: %s", err)
+	}
 	sharedFile, err := windows.CreateFileMapping(
 		windows.InvalidHandle,
-		nil,
+		sa,
 		windows.PAGE_READWRITE,
 		0,
-		agentMaxMsglen,
+		uint32(msglen),
 		mapNameUTF16,
 	)
 	if err != nil {
@@ -237,23 +447,190 @@ func (c *Conn) establishConn() error {
 	if err != nil {
 		return fmt.Errorf("failed to map file into shared memory: %s", err)
 	}
-	*c = Conn{
-		window:     windows.Handle(window),
-		sharedFile: sharedFile,
-		sharedMem:  sharedMem,
-		mapName:    mapName,
+	c.window = windows.Handle(window)
+	c.sharedFile = sharedFile
+	c.sharedMem = sharedMem
+	c.mapName = mapName
+	c.msglen = msglen
+	return nil
+}
+
+// pageantMsglen returns the longest message the running Pageant accepts,
+// found out with queryLargeMessages on first use of its window, so that
+// small requests get the large responses of Pageant 0.75 and later too.
+// The answer is kept for as long as the Pageant window lives.
+// The caller must hold the lock.
+func (c *Conn) pageantMsglen() (int, error) {
+	window, err := PageantWindow()
+	if err != nil {
+		return 0, err
 	}
+	pageantMsglenCache.Lock()
+	msglen := 0
+	if pageantMsglenCache.window == window {
+		msglen = pageantMsglenCache.msglen
+	}
+	pageantMsglenCache.Unlock()
+	if msglen != 0 {
+		return msglen, nil
+	}
+
+	large, err := c.queryLargeMessages()
+	if err != nil {
+		return 0, err
+	}
+	msglen = agentMaxMsglen
+	if large {
+		msglen = pageantLargeMsglen
+	}
+	pageantMsglenCache.Lock()
+	pageantMsglenCache.window = window
+	pageantMsglenCache.msglen = msglen
+	pageantMsglenCache.Unlock()
+	return msglen, nil
+}
+
+// pageantConnMsglen returns the longest message conn, a Pageant connection,
+// carries.
+func pageantConnMsglen(conn net.Conn) (int, error) {
+	c := conn.(*Conn)
+	c.Lock()
+	defer c.Unlock()
+	if c.readOffset < c.readLimit {
+		// probing would overwrite the unread response
+		return c.msglen, nil
+	}
+	return c.pageantMsglen()
+}
+
+// queryLargeMessages sends the query extension, which Pageant answers
+// since it takes messages up to pageantLargeMsglen, and fails before.
+func (c *Conn) queryLargeMessages() (bool, error) {
+	query := ssh.Marshal(struct {
+		Type      byte
+		Extension string
+	}{agentcExtension, "query"})
+	request := make([]byte, 4+len(query))
+	binary.BigEndian.PutUint32(request, uint32(len(query)))
+	copy(request[4:], query)
+
+	if err := c.establishConn(agentMaxMsglen); err != nil {
+		return false, err
+	}
+	defer c.release()
+	copy(toSlice(c.sharedMem, len(request)), request)
+	data := make([]byte, len(c.mapName)+1)
+	copy(data, c.mapName)
+	timeout, err := c.sendTimeout()
+	if err != nil {
+		return false, err
+	}
+	result, err := c.sendMessage(data, timeout)
+	if err == os.ErrDeadlineExceeded {
+		return false, err
+	}
+	if result == 0 {
+		return false, nil
+	}
+	response := toSlice(c.sharedMem, 5)
+	return binary.BigEndian.Uint32(response) > 0 && response[4] == agentSuccess, nil
+}
+
+// newMapName returns a name for the shared file of a new request.
+// The name is random so that other processes cannot guess it and race to
+// open the mapping, unless Options.CompatibleMapName asks for the
+// traditional name derived from the thread id.
+func (c *Conn) newMapName() (string, error) {
+	if c.opts != nil && c.opts.CompatibleMapName {
+		return fmt.Sprintf("PageantRequest%08x", windows.GetCurrentThreadId()), nil
+	}
+	var suffix [16]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", fmt.Errorf("failed to generate shared file name: %s", err)
+	}
+	return fmt.Sprintf("PageantRequest%x", suffix), nil
+}
+
+// mappingSecurity returns security attributes restricting the shared file
+// to the current user, who is also made its owner as Pageant expects.
+// An elevated process labels it with medium integrity so that Pageant
+// running unelevated is still able to write its response.
+func mappingSecurity() (*windows.SecurityAttributes, error) {
+	token := windows.GetCurrentProcessToken()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	sid := user.User.Sid.String()
+	sddl := fmt.Sprintf("O:%sD:P(A;;GA;;;%s)", sid, sid)
+	if token.IsElevated() {
+		sddl += "S:(ML;;NW;;;ME)"
+	}
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	return &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+// maxPendingBytes returns the Options.MaxPendingBytes of o, which may be
+// nil, or room for the ResponseQueue responses of msglen.
+func (o *Options) maxPendingBytes(msglen int) int {
+	if o == nil || o.MaxPendingBytes <= 0 {
+		return o.responseQueue() * msglen
+	}
+	return o.MaxPendingBytes
+}
+
+// verifyWindow checks the publisher of the process owning window when
+// Options.VerifyPublisher is set. A window is only verified once.
+func (c *Conn) verifyWindow(window uintptr) error {
+	if c.opts == nil || !c.opts.VerifyPublisher || c.verified == windows.Handle(window) {
+		return nil
+	}
+	pid, err := windowProcessID(window)
+	if err != nil {
+		return err
+	}
+	if err := verifyPublisher(pid, c.opts.publishers(true)); err != nil {
+		return err
+	}
+	c.verified = windows.Handle(window)
 	return nil
 }
 
 // sendMessage invokes user32.SendMessage to alert Pageant that data
-// is available for it to read.
-func (c *Conn) sendMessage(data []byte) (uintptr, error) {
+// is available for it to read. A positive timeout uses SendMessageTimeout
+// instead, which gives up with os.ErrDeadlineExceeded rather than waiting
+// for a Pageant stuck, for instance, on a passphrase prompt.
+func (c *Conn) sendMessage(data []byte, timeout time.Duration) (uintptr, error) {
 	cds := copyData{
 		dwData: agentCopydataID,
 		cbData: uintptr(len(data)),
 		lpData: uintptr(unsafe.Pointer(&data[0])),
 	}
+	if timeout > 0 {
+		var result uintptr
+		ok, _, err := sendMessageTimeout.Call(
+			uintptr(c.window),
+			wmCopyData,
+			0,
+			uintptr(unsafe.Pointer(&cds)),
+			smtoErrorOnExit,
+			uintptr((timeout+time.Millisecond-1)/time.Millisecond),
+			uintptr(unsafe.Pointer(&result)),
+		)
+		if ok == 0 {
+			if err == windows.ERROR_TIMEOUT {
+				return 0, os.ErrDeadlineExceeded
+			}
+			return 0, err
+		}
+		return result, nil
+	}
 	result, _, err := sendMessage.Call(
 		uintptr(c.window),
 		wmCopyData,
@@ -266,6 +643,26 @@ func (c *Conn) sendMessage(data []byte) (uintptr, error) {
 	return result, err
 }
 
+// sendTimeout returns how long the next request may wait for Pageant given
+// Options.SendTimeout and the write deadline, or 0 to wait for as long as
+// it takes.
+func (c *Conn) sendTimeout() (time.Duration, error) {
+	var timeout time.Duration
+	if c.opts != nil {
+		timeout = c.opts.SendTimeout
+	}
+	if deadline := c.writeDeadline.Load(); deadline != 0 {
+		left := time.Until(time.Unix(0, deadline))
+		if left <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+	return timeout, nil
+}
+
 // copyData is equivalent to COPYDATASTRUCT.
 // Unlike Java, Go has a native type that matches the bit width of the
 // platform, so there is no need for separate 32-bit and 64-bit versions.
@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package pageant
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// connPeer names the client of conn, the process of a unix socket
+// connection, or returns "" if it is unknown.
+func connPeer(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ""
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ""
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return ""
+	}
+	return fmt.Sprintf("pid %d uid %d", cred.Pid, cred.Uid)
+}
@@ -0,0 +1,243 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                    = windows.NewLazySystemDLL("kernel32.dll")
+	getNamedPipeServerProcessId = kernel32.NewProc("GetNamedPipeServerProcessId")
+)
+
+// dialPipe connects to the named pipe agent at path as tuned by opts.
+func dialPipe(path string, opts *Options) (net.Conn, error) {
+	timeout := opts.PipeDialTimeout
+	if timeout <= 0 {
+		timeout = DefaultPipeDialTimeout
+	}
+	conn, err := winio.DialPipe(path, &timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyPipe(conn, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if systemConfig().RequireOwnAgent && !opts.VerifyPipeServer {
+		if err := verifyPipeOwner(conn); err != nil {
+			conn.Close()
+			return nil, &policyError{err}
+		}
+	}
+	if opts.PipeMessageMode {
+		mode := uint32(windows.PIPE_READMODE_MESSAGE)
+		pipe := conn.(interface{ Fd() uintptr })
+		if err := windows.SetNamedPipeHandleState(windows.Handle(pipe.Fd()), &mode, nil, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set message mode on agent pipe: %s", err)
+		}
+	}
+	if opts.ReadBufferSize > 0 {
+		return &bufferedConn{Conn: conn, reader: bufio.NewReaderSize(conn, opts.ReadBufferSize)}, nil
+	}
+	return conn, nil
+}
+
+// pipeAvailable tells whether the named pipe agent accepts connections
+// within timeout.
+func pipeAvailable(timeout time.Duration) bool {
+	path, socket := pipePath(&Options{})
+	var conn net.Conn
+	var err error
+	if socket {
+		conn, err = net.DialTimeout("unix", path, timeout)
+	} else {
+		conn, err = winio.DialPipe(path, &timeout)
+	}
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// listenPipe creates the named pipe path for an agent, which only the
+// current user may connect to.
+func listenPipe(path string) (net.Listener, error) {
+	path, socket := normalizePipePath(path, DefaultPipePrefix)
+	if socket {
+		return nil, fmt.Errorf("agent address %q is not a named pipe", path)
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get current user: %s", err)
+	}
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		SecurityDescriptor: fmt.Sprintf("D:P(A;;GA;;;%s)", user.User.Sid),
+	})
+}
+
+// bufferedConn reads its connection through a buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite closes the write side of the named pipe, as winio allows.
+func (c *bufferedConn) CloseWrite() error {
+	return c.Conn.(interface{ CloseWrite() error }).CloseWrite()
+}
+
+// verifyPipe checks the process serving the named pipe behind conn as
+// requested by Options.VerifyPublisher and Options.VerifyPipeServer.
+func verifyPipe(conn net.Conn, opts *Options) error {
+	if !opts.VerifyPublisher && !opts.VerifyPipeServer {
+		return nil
+	}
+	pid, err := pipeServerProcessID(conn)
+	if err != nil {
+		return err
+	}
+	if opts.VerifyPipeServer {
+		if err := verifyPipeOwner(conn); err != nil {
+			return err
+		}
+		if err := verifyPipeServerPath(pid, opts.AllowedPipeServers); err != nil {
+			return err
+		}
+	}
+	if opts.VerifyPublisher {
+		return verifyPublisher(pid, opts.publishers(false))
+	}
+	return nil
+}
+
+// pipeServerProcessID returns the id of the process serving the named pipe
+// behind conn, which must come from winio.DialPipe.
+func pipeServerProcessID(conn net.Conn) (uint32, error) {
+	pipe, ok := conn.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, fmt.Errorf("connection is not a named pipe")
+	}
+	var pid uint32
+	result, _, err := getNamedPipeServerProcessId.Call(pipe.Fd(), uintptr(unsafe.Pointer(&pid)))
+	if result == 0 {
+		return 0, fmt.Errorf("cannot get pipe server process: %s", err)
+	}
+	return pid, nil
+}
+
+// verifyPipeOwner checks that the named pipe behind conn was created by the
+// current user or by the LocalSystem account of the OpenSSH agent service,
+// which owns its objects either directly or through Administrators.
+// The owner of the pipe is used rather than the token of the server process
+// because a standard user may not query the token of a service.
+func verifyPipeOwner(conn net.Conn) error {
+	pipe := conn.(interface{ Fd() uintptr })
+	sd, err := windows.GetSecurityInfo(windows.Handle(pipe.Fd()), windows.SE_KERNEL_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("cannot get owner of agent pipe: %s", err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("cannot get owner of agent pipe: %s", err)
+	}
+	if err := verifyOwner(owner); err != nil {
+		return fmt.Errorf("agent pipe is owned by untrusted %s", owner)
+	}
+	return nil
+}
+
+// verifySocketOwner checks that the unix socket agent at path belongs to
+// the current user, LocalSystem or Administrators.
+func verifySocketOwner(path string) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("cannot get owner of agent socket: %s", err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("cannot get owner of agent socket: %s", err)
+	}
+	if err := verifyOwner(owner); err != nil {
+		return fmt.Errorf("agent socket is owned by untrusted %s", owner)
+	}
+	return nil
+}
+
+// verifyWindowOwner checks that the process of the Pageant window runs as
+// the current user, LocalSystem or Administrators.
+func verifyWindowOwner(window uintptr) error {
+	pid, err := windowProcessID(window)
+	if err != nil {
+		return err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return fmt.Errorf("cannot open process %d: %s", pid, err)
+	}
+	defer windows.CloseHandle(process)
+	var token windows.Token
+	if err := windows.OpenProcessToken(process, windows.TOKEN_QUERY, &token); err != nil {
+		return fmt.Errorf("cannot open token of Pageant process: %s", err)
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("cannot get user of Pageant process: %s", err)
+	}
+	if err := verifyOwner(user.User.Sid); err != nil {
+		return fmt.Errorf("untrusted %s runs Pageant", user.User.Sid)
+	}
+	return nil
+}
+
+// verifyOwner checks that owner is the current user, LocalSystem or
+// Administrators.
+func verifyOwner(owner *windows.SID) error {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("cannot get current user: %s", err)
+	}
+	if owner.Equals(user.User.Sid) ||
+		owner.IsWellKnown(windows.WinLocalSystemSid) ||
+		owner.IsWellKnown(windows.WinBuiltinAdministratorsSid) {
+		return nil
+	}
+	return fmt.Errorf("untrusted owner %s", owner)
+}
+
+// verifyPipeServerPath checks that the executable of process pid is one of
+// allowed. Any executable is accepted if allowed is empty.
+func verifyPipeServerPath(pid uint32, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	path, err := processImagePath(pid)
+	if err != nil {
+		return err
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(filepath.Clean(a), filepath.Clean(path)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("agent pipe is served by untrusted %s", path)
+}
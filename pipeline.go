@@ -0,0 +1,189 @@
+package pageant
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipelineMaxMsglen bounds responses read by Pipeline, as the 256 KiB
+// AGENT_MAX_LEN of OpenSSH does.
+const pipelineMaxMsglen = 256 * 1024
+
+// Pipeline shares one connection to a named pipe or unix socket agent
+// between goroutines, keeping several requests in flight. Agents answer
+// requests in order, so responses are matched to requests first in, first
+// out. Pageant's shared memory transport cannot pipeline and is refused.
+// It is safe to use Pipeline in multiple concurrent goroutines.
+type Pipeline struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	waiting []chan pipelineResult
+	err     error
+}
+
+type pipelineResult struct {
+	response []byte
+	err      error
+}
+
+// NewPipeline connects to the agent with NewConnWithOptions.
+// Ensure Close gets called on the returned Pipeline when it is no longer needed.
+func NewPipeline(opts *Options) (*Pipeline, error) {
+	conn, err := NewConnWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if isPageantConn(conn) {
+		conn.Close()
+		return nil, fmt.Errorf("pipelining needs a named pipe or unix socket agent, not Pageant")
+	}
+	p := &Pipeline{conn: conn}
+	go p.readLoop()
+	return p, nil
+}
+
+// Call sends a framed request, length included, and waits for its framed
+// response.
+func (p *Pipeline) Call(request []byte) ([]byte, error) {
+	done := make(chan pipelineResult, 1)
+
+	p.writeMu.Lock()
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		p.writeMu.Unlock()
+		return nil, err
+	}
+	p.waiting = append(p.waiting, done)
+	p.mu.Unlock()
+	_, err := p.conn.Write(request)
+	p.writeMu.Unlock()
+	if err != nil {
+		// a partial write leaves the stream out of sync for everybody
+		p.fail(fmt.Errorf("failed to send request to agent: %s", err))
+	}
+
+	result := <-done
+	return result.response, result.err
+}
+
+// Conn returns a connection for agent.NewClient whose requests are sent
+// through p. Each goroutine should use its own.
+func (p *Pipeline) Conn() net.Conn {
+	return &pipelineConn{pipeline: p}
+}
+
+// Close closes the connection to the agent and fails pending requests.
+func (p *Pipeline) Close() error {
+	p.fail(net.ErrClosed)
+	return p.conn.Close()
+}
+
+// readLoop hands each response to the oldest waiting request.
+func (p *Pipeline) readLoop() {
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(p.conn, header); err != nil {
+			p.fail(fmt.Errorf("failed to read response from agent: %s", err))
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > pipelineMaxMsglen-4 {
+			p.fail(fmt.Errorf("size of response message (%d) exceeds max length (%d)", size+4, pipelineMaxMsglen))
+			return
+		}
+		response := make([]byte, 4+size)
+		copy(response, header)
+		if _, err := io.ReadFull(p.conn, response[4:]); err != nil {
+			p.fail(fmt.Errorf("failed to read response from agent: %s", err))
+			return
+		}
+
+		p.mu.Lock()
+		if len(p.waiting) == 0 {
+			p.mu.Unlock()
+			p.fail(fmt.Errorf("unexpected response from agent"))
+			return
+		}
+		done := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		p.mu.Unlock()
+		done <- pipelineResult{response: response}
+	}
+}
+
+// fail fails the pending and all later requests with err.
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	for _, done := range p.waiting {
+		done <- pipelineResult{err: p.err}
+	}
+	p.waiting = nil
+}
+
+// pipelineConn collects a framed request from Write, calls the Pipeline and
+// serves the response to Read.
+type pipelineConn struct {
+	pipeline *Pipeline
+	request  []byte
+	response []byte
+}
+
+func (c *pipelineConn) Write(p []byte) (int, error) {
+	c.request = append(c.request, p...)
+	if len(c.request) < 4 || len(c.request) < 4+int(binary.BigEndian.Uint32(c.request)) {
+		return len(p), nil
+	}
+	if len(c.request) > 4+int(binary.BigEndian.Uint32(c.request)) {
+		c.request = nil
+		return 0, fmt.Errorf("write exceeds the request message")
+	}
+	response, err := c.pipeline.Call(c.request)
+	c.request = nil
+	if err != nil {
+		return 0, err
+	}
+	c.response = response
+	return len(p), nil
+}
+
+func (c *pipelineConn) Read(p []byte) (int, error) {
+	if len(c.response) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.response)
+	c.response = c.response[n:]
+	return n, nil
+}
+
+func (c *pipelineConn) Close() error {
+	c.request = nil
+	c.response = nil
+	return nil
+}
+
+func (c *pipelineConn) LocalAddr() net.Addr {
+	return c.pipeline.conn.LocalAddr()
+}
+func (c *pipelineConn) RemoteAddr() net.Addr {
+	return c.pipeline.conn.RemoteAddr()
+}
+func (c *pipelineConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+func (c *pipelineConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+func (c *pipelineConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPipeline(t *testing.T) {
+	keyring := agent.NewKeyring()
+	var signers []ssh.Signer
+	for i := 0; i < 8; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on GenerateKey: %s", err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+		signer, _ := ssh.NewSignerFromKey(priv)
+		signers = append(signers, signer)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	go Serve(keyring, listener)
+
+	pipeline, err := NewPipeline(&Options{Backends: []string{BackendUnix}, AgentPath: socket})
+	if err != nil {
+		t.Fatalf("error on NewPipeline: %s", err)
+	}
+	defer pipeline.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(signers))
+	for _, signer := range signers {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(pub ssh.PublicKey, i int) {
+				defer wg.Done()
+				client := agent.NewClient(pipeline.Conn())
+				// a response out of order would be a signature by another key
+				// or an identity list
+				data := []byte(fmt.Sprintf("data %d", i))
+				if i%2 == 0 {
+					keys, err := client.List()
+					if err == nil && len(keys) != len(signers) {
+						err = fmt.Errorf("listed %d keys rather than %d", len(keys), len(signers))
+					}
+					errs <- err
+					return
+				}
+				sig, err := client.Sign(pub, data)
+				if err == nil {
+					err = pub.Verify(data, sig)
+				}
+				errs <- err
+			}(signer.PublicKey(), i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("error through Pipeline: %s", err)
+		}
+	}
+}
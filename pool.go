@@ -0,0 +1,182 @@
+package pageant
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// poolCheckIdle is how long a pooled connection may stay idle before Get
+// checks that the agent still answers on it.
+const poolCheckIdle = 30 * time.Second
+
+// Pool keeps connections to the agent, dialed with NewConnWithOptions on
+// first use, for reuse by concurrent goroutines.
+// It is safe to use Pool in multiple concurrent goroutines.
+type Pool struct {
+	opts   *Options
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+}
+
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// NewPool returns a pool of at most size connections tuned by opts.
+func NewPool(size int, opts *Options) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{opts: opts, slots: make(chan struct{}, size)}
+}
+
+// Get returns an idle connection or dials a new one, waiting for one to be
+// put back while size connections are in use.
+// Ensure Put gets called with the returned connection.
+func (p *Pool) Get() (net.Conn, error) {
+	p.slots <- struct{}{}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, fmt.Errorf("pool is closed")
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(idle.since) < poolCheckIdle {
+			return idle.conn, nil
+		}
+		if _, err := agent.NewClient(idle.conn).List(); err == nil {
+			return idle.conn, nil
+		}
+		idle.conn.Close()
+	}
+
+	conn, err := NewConnWithOptions(p.opts)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Put gives back a connection returned by Get. A non nil err tells that
+// using conn failed, which closes it rather than keeping it for reuse.
+func (p *Pool) Put(conn net.Conn, err error) {
+	defer func() { <-p.slots }()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil || p.closed {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+}
+
+// Do calls f with an agent client on a pooled connection.
+func (p *Pool) Do(f func(agent.ExtendedAgent) error) error {
+	conn, err := p.Get()
+	if err != nil {
+		return err
+	}
+	err = f(agent.NewClient(conn))
+	p.Put(conn, err)
+	return err
+}
+
+// Close closes the idle connections and makes Get fail. Connections in use
+// are closed when they are put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var err error
+	for _, idle := range p.idle {
+		if e := idle.conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	p.idle = nil
+	return err
+}
+
+// Agent returns an agent making each call on a pooled connection.
+// It is safe to use in multiple concurrent goroutines.
+func (p *Pool) Agent() agent.ExtendedAgent {
+	return poolAgent{p}
+}
+
+type poolAgent struct {
+	pool *Pool
+}
+
+func (a poolAgent) List() (keys []*agent.Key, err error) {
+	err = a.pool.Do(func(c agent.ExtendedAgent) error {
+		keys, err = c.List()
+		return err
+	})
+	return
+}
+
+func (a poolAgent) Sign(key ssh.PublicKey, data []byte) (sig *ssh.Signature, err error) {
+	err = a.pool.Do(func(c agent.ExtendedAgent) error {
+		sig, err = c.Sign(key, data)
+		return err
+	})
+	return
+}
+
+func (a poolAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (sig *ssh.Signature, err error) {
+	err = a.pool.Do(func(c agent.ExtendedAgent) error {
+		sig, err = c.SignWithFlags(key, data, flags)
+		return err
+	})
+	return
+}
+
+func (a poolAgent) Add(key agent.AddedKey) error {
+	return a.pool.Do(func(c agent.ExtendedAgent) error { return c.Add(key) })
+}
+
+func (a poolAgent) Remove(key ssh.PublicKey) error {
+	return a.pool.Do(func(c agent.ExtendedAgent) error { return c.Remove(key) })
+}
+
+func (a poolAgent) RemoveAll() error {
+	return a.pool.Do(func(c agent.ExtendedAgent) error { return c.RemoveAll() })
+}
+
+func (a poolAgent) Lock(passphrase []byte) error {
+	return a.pool.Do(func(c agent.ExtendedAgent) error { return c.Lock(passphrase) })
+}
+
+func (a poolAgent) Unlock(passphrase []byte) error {
+	return a.pool.Do(func(c agent.ExtendedAgent) error { return c.Unlock(passphrase) })
+}
+
+func (a poolAgent) Signers() ([]ssh.Signer, error) {
+	return agentSigners(a)
+}
+
+func (a poolAgent) Extension(extensionType string, contents []byte) (response []byte, err error) {
+	err = a.pool.Do(func(c agent.ExtendedAgent) error {
+		response, err = c.Extension(extensionType, contents)
+		return err
+	})
+	return
+}
@@ -0,0 +1,44 @@
+package pageant
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestPool(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	go Serve(agent.NewKeyring(), listener)
+
+	pool := NewPool(2, &Options{Backends: []string{BackendUnix}, AgentPath: socket})
+	defer pool.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- pool.Do(func(sshAgent agent.ExtendedAgent) error {
+				_, err := sshAgent.List()
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("error on Pool.Do: %s", err)
+		}
+	}
+	if len(pool.idle) > 2 {
+		t.Fatalf("pool keeps %d connections", len(pool.idle))
+	}
+}
@@ -0,0 +1,294 @@
+package pageant
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// ppkFile is the content of a PuTTY .ppk private key file.
+type ppkFile struct {
+	version    int
+	algorithm  string
+	encryption string
+	comment    string
+	public     []byte
+	private    []byte
+	mac        []byte
+	headers    map[string]string
+}
+
+// ParsePuTTYKey parses a private key in the PuTTY .ppk format, versions 2
+// and 3, returning it as ssh.ParseRawPrivateKey does, along with its
+// comment. Encrypted keys fail with *ssh.PassphraseMissingError.
+func ParsePuTTYKey(data []byte) (interface{}, string, error) {
+	return parsePuTTYKey(data, nil)
+}
+
+// ParsePuTTYKeyWithPassphrase is ParsePuTTYKey for keys encrypted with
+// passphrase. Unencrypted keys are parsed as well.
+func ParsePuTTYKeyWithPassphrase(data, passphrase []byte) (interface{}, string, error) {
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	return parsePuTTYKey(data, passphrase)
+}
+
+func parsePuTTYKey(data, passphrase []byte) (interface{}, string, error) {
+	f, err := readPPK(data)
+	if err != nil {
+		return nil, "", err
+	}
+	pub, err := ssh.ParsePublicKey(f.public)
+	if err != nil {
+		return nil, "", fmt.Errorf("ppk: invalid public key: %s", err)
+	}
+	if pub.Type() != f.algorithm {
+		return nil, "", fmt.Errorf("ppk: public key %s is not %s", pub.Type(), f.algorithm)
+	}
+
+	encrypted := f.encryption == "aes256-cbc"
+	if !encrypted && f.encryption != "none" {
+		return nil, "", fmt.Errorf("ppk: unsupported encryption %q", f.encryption)
+	}
+	if encrypted && passphrase == nil {
+		return nil, "", &ssh.PassphraseMissingError{PublicKey: pub}
+	}
+	if !encrypted {
+		passphrase = nil
+	}
+
+	cipherKey, iv, macKey, newMAC, err := f.keys(passphrase, encrypted)
+	if err != nil {
+		return nil, "", err
+	}
+	private := f.private
+	if encrypted {
+		if len(private) == 0 || len(private)%aes.BlockSize != 0 {
+			return nil, "", fmt.Errorf("ppk: invalid encrypted private key length %d", len(private))
+		}
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			return nil, "", err
+		}
+		private = make([]byte, len(f.private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(private, f.private)
+	}
+
+	mac := hmac.New(newMAC, macKey)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{f.algorithm, f.encryption, f.comment, f.public, private}))
+	if !hmac.Equal(mac.Sum(nil), f.mac) {
+		if encrypted {
+			return nil, "", fmt.Errorf("ppk: wrong passphrase")
+		}
+		return nil, "", fmt.Errorf("ppk: corrupt key file, MAC mismatch")
+	}
+
+	key, err := ppkPrivateKey(pub, private)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, f.comment, nil
+}
+
+// readPPK splits a .ppk file into its headers and key blobs.
+func readPPK(data []byte) (*ppkFile, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	f := &ppkFile{headers: make(map[string]string)}
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(lines[i], ": ")
+		if !ok {
+			return nil, fmt.Errorf("ppk: invalid line %d", i+1)
+		}
+		if i == 0 {
+			switch name {
+			case "PuTTY-User-Key-File-2":
+				f.version = 2
+			case "PuTTY-User-Key-File-3":
+				f.version = 3
+			default:
+				return nil, fmt.Errorf("ppk: not a PuTTY key file of version 2 or 3")
+			}
+			f.algorithm = value
+			continue
+		}
+		switch name {
+		case "Public-Lines", "Private-Lines":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || i+n >= len(lines) {
+				return nil, fmt.Errorf("ppk: invalid %s %q", name, value)
+			}
+			blob, err := base64.StdEncoding.DecodeString(strings.Join(lines[i+1:i+1+n], ""))
+			if err != nil {
+				return nil, fmt.Errorf("ppk: invalid %s: %s", name, err)
+			}
+			if name == "Public-Lines" {
+				f.public = blob
+			} else {
+				f.private = blob
+			}
+			i += n
+		case "Private-MAC":
+			mac, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("ppk: invalid Private-MAC: %s", err)
+			}
+			f.mac = mac
+		default:
+			f.headers[name] = value
+		}
+	}
+	if f.version == 0 {
+		return nil, fmt.Errorf("ppk: not a PuTTY key file of version 2 or 3")
+	}
+	if f.public == nil || f.private == nil || f.mac == nil {
+		return nil, fmt.Errorf("ppk: missing public key, private key or MAC")
+	}
+	f.encryption = f.headers["Encryption"]
+	f.comment = f.headers["Comment"]
+	return f, nil
+}
+
+// ppkMaxArgon2Memory bounds the Argon2-Memory, in KiB, of the files read,
+// which are parsed before their MAC is checked. PuTTYgen uses 8 MiB.
+const ppkMaxArgon2Memory = 1024 * 1024
+
+// keys derives the cipher key, IV and MAC key of f from passphrase, as
+// PuTTY does for f.version, and returns the hash of its MAC.
+func (f *ppkFile) keys(passphrase []byte, encrypted bool) (cipherKey, iv, macKey []byte, newMAC func() hash.Hash, err error) {
+	if f.version == 2 {
+		macHash := sha1.New()
+		macHash.Write([]byte("putty-private-key-file-mac-key"))
+		macHash.Write(passphrase)
+		macKey = macHash.Sum(nil)
+		if encrypted {
+			for i := byte(0); i < 2; i++ {
+				h := sha1.New()
+				h.Write([]byte{0, 0, 0, i})
+				h.Write(passphrase)
+				cipherKey = h.Sum(cipherKey)
+			}
+			cipherKey = cipherKey[:32]
+			iv = make([]byte, aes.BlockSize)
+		}
+		return cipherKey, iv, macKey, sha1.New, nil
+	}
+
+	if !encrypted {
+		return nil, nil, []byte{}, sha256.New, nil
+	}
+	params := make(map[string]uint64)
+	for _, name := range []string{"Argon2-Memory", "Argon2-Passes", "Argon2-Parallelism"} {
+		n, err := strconv.ParseUint(f.headers[name], 10, 32)
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("ppk: invalid %s %q", name, f.headers[name])
+		}
+		params[name] = n
+	}
+	for name, n := range params {
+		if n < 1 {
+			return nil, nil, nil, nil, fmt.Errorf("ppk: invalid %s %d", name, n)
+		}
+	}
+	if params["Argon2-Parallelism"] > 255 {
+		return nil, nil, nil, nil, fmt.Errorf("ppk: invalid Argon2-Parallelism %d", params["Argon2-Parallelism"])
+	}
+	if params["Argon2-Memory"] > ppkMaxArgon2Memory {
+		return nil, nil, nil, nil, fmt.Errorf("ppk: Argon2-Memory %d KiB exceeds %d KiB", params["Argon2-Memory"], ppkMaxArgon2Memory)
+	}
+	salt, err := hex.DecodeString(f.headers["Argon2-Salt"])
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("ppk: invalid Argon2-Salt: %s", err)
+	}
+	derive := argon2.IDKey
+	switch f.headers["Key-Derivation"] {
+	case "Argon2id":
+	case "Argon2i":
+		derive = argon2.Key
+	default:
+		return nil, nil, nil, nil, fmt.Errorf("ppk: unsupported key derivation %q", f.headers["Key-Derivation"])
+	}
+	out := derive(passphrase, salt, uint32(params["Argon2-Passes"]), uint32(params["Argon2-Memory"]),
+		uint8(params["Argon2-Parallelism"]), 32+aes.BlockSize+32)
+	return out[:32], out[32 : 32+aes.BlockSize], out[32+aes.BlockSize:], sha256.New, nil
+}
+
+// ppkPrivateKey builds the private key of pub from the private blob of a
+// .ppk file.
+func ppkPrivateKey(pub ssh.PublicKey, private []byte) (interface{}, error) {
+	cryptoPub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("ppk: unsupported key type %s", pub.Type())
+	}
+	switch pubKey := cryptoPub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		var blob struct {
+			D, P, Q, Iqmp *big.Int
+			Rest          []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &blob); err != nil {
+			return nil, fmt.Errorf("ppk: invalid RSA private key: %s", err)
+		}
+		key := &rsa.PrivateKey{PublicKey: *pubKey, D: blob.D, Primes: []*big.Int{blob.P, blob.Q}}
+		if err := key.Validate(); err != nil {
+			return nil, fmt.Errorf("ppk: invalid RSA private key: %s", err)
+		}
+		key.Precompute()
+		return key, nil
+	case *ecdsa.PublicKey:
+		var blob struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &blob); err != nil {
+			return nil, fmt.Errorf("ppk: invalid ECDSA private key: %s", err)
+		}
+		key := &ecdsa.PrivateKey{PublicKey: *pubKey, D: blob.D}
+		if x, y := pubKey.Curve.ScalarBaseMult(blob.D.Bytes()); x.Cmp(pubKey.X) != 0 || y.Cmp(pubKey.Y) != 0 {
+			return nil, errors.New("ppk: ECDSA private key does not match its public key")
+		}
+		return key, nil
+	case ed25519.PublicKey:
+		var blob struct {
+			Seed []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &blob); err != nil {
+			return nil, fmt.Errorf("ppk: invalid Ed25519 private key: %s", err)
+		}
+		if len(blob.Seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ppk: invalid Ed25519 private key length %d", len(blob.Seed))
+		}
+		key := ed25519.NewKeyFromSeed(blob.Seed)
+		if !pubKey.Equal(key.Public()) {
+			return nil, errors.New("ppk: Ed25519 private key does not match its public key")
+		}
+		return &key, nil
+	}
+	return nil, fmt.Errorf("ppk: unsupported key type %s", pub.Type())
+}
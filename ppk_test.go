@@ -0,0 +1,165 @@
+package pageant
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// writePPK encodes key as PuTTY does in a .ppk file of version, encrypted
+// with passphrase unless it is empty.
+func writePPK(t *testing.T, version int, key crypto.Signer, comment, passphrase string) []byte {
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("error on NewPublicKey: %s", err)
+	}
+	var private []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		private = ssh.Marshal(struct{ D, P, Q, Iqmp *big.Int }{k.D, k.Primes[0], k.Primes[1], k.Precomputed.Qinv})
+	case *ecdsa.PrivateKey:
+		private = ssh.Marshal(struct{ D *big.Int }{k.D})
+	case ed25519.PrivateKey:
+		private = ssh.Marshal(struct{ Seed []byte }{k.Seed()})
+	}
+
+	encryption := "none"
+	var headers string
+	var cipherKey, iv, macKey []byte
+	newMAC := sha256.New
+	if version == 2 {
+		newMAC = sha1.New
+		h := sha1.Sum(append([]byte("putty-private-key-file-mac-key"), passphrase...))
+		macKey = h[:]
+	}
+	if passphrase != "" {
+		encryption = "aes256-cbc"
+		if padding := len(private) % aes.BlockSize; padding != 0 {
+			private = append(private, make([]byte, aes.BlockSize-padding)...)
+		}
+		if version == 2 {
+			k0 := sha1.Sum(append([]byte{0, 0, 0, 0}, passphrase...))
+			k1 := sha1.Sum(append([]byte{0, 0, 0, 1}, passphrase...))
+			cipherKey = append(k0[:], k1[:12]...)
+			iv = make([]byte, aes.BlockSize)
+		} else {
+			salt := []byte("0123456789abcdef")
+			out := argon2.IDKey([]byte(passphrase), salt, 2, 64, 1, 80)
+			cipherKey, iv, macKey = out[:32], out[32:48], out[48:]
+			headers = fmt.Sprintf("Key-Derivation: Argon2id\nArgon2-Memory: 64\nArgon2-Passes: 2\nArgon2-Parallelism: 1\nArgon2-Salt: %x\n", salt)
+		}
+	}
+
+	mac := hmac.New(newMAC, macKey)
+	mac.Write(ssh.Marshal(struct {
+		Algorithm, Encryption, Comment string
+		Public, Private                []byte
+	}{pub.Type(), encryption, comment, pub.Marshal(), private}))
+	encrypted := private
+	if passphrase != "" {
+		block, _ := aes.NewCipher(cipherKey)
+		encrypted = make([]byte, len(private))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, private)
+	}
+
+	lines := func(blob []byte) string {
+		encoded := base64.StdEncoding.EncodeToString(blob)
+		var result []string
+		for len(encoded) > 64 {
+			result = append(result, encoded[:64])
+			encoded = encoded[64:]
+		}
+		result = append(result, encoded)
+		return fmt.Sprintf("%d\r\n%s\r\n", len(result), strings.Join(result, "\r\n"))
+	}
+	return []byte(fmt.Sprintf("PuTTY-User-Key-File-%d: %s\r\nEncryption: %s\r\nComment: %s\r\nPublic-Lines: %s%sPrivate-Lines: %sPrivate-MAC: %s\r\n",
+		version, pub.Type(), encryption, comment, lines(pub.Marshal()), strings.ReplaceAll(headers, "\n", "\r\n"),
+		lines(encrypted), hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestParsePuTTYKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error on rsa.GenerateKey: %s", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error on ecdsa.GenerateKey: %s", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on ed25519.GenerateKey: %s", err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, ecdsaKey, ed25519Key} {
+		for _, version := range []int{2, 3} {
+			for _, passphrase := range []string{"", "secret"} {
+				name := fmt.Sprintf("%T v%d %q", key, version, passphrase)
+				data := writePPK(t, version, key, "test key", passphrase)
+
+				parsed, comment, err := ParsePuTTYKey(data)
+				if passphrase != "" {
+					var missing *ssh.PassphraseMissingError
+					if !errors.As(err, &missing) {
+						t.Fatalf("%s: ParsePuTTYKey did not ask for the passphrase: %v", name, err)
+					}
+					if _, _, err := ParsePuTTYKeyWithPassphrase(data, []byte("wrong")); err == nil {
+						t.Fatalf("%s: ParsePuTTYKeyWithPassphrase accepted a wrong passphrase", name)
+					}
+					parsed, comment, err = ParsePuTTYKeyWithPassphrase(data, []byte(passphrase))
+				}
+				if err != nil {
+					t.Fatalf("%s: error on parsing: %s", name, err)
+				}
+				if comment != "test key" {
+					t.Fatalf("%s: comment %q is not %q", name, comment, "test key")
+				}
+				signer, err := ssh.NewSignerFromKey(parsed)
+				if err != nil {
+					t.Fatalf("%s: error on NewSignerFromKey: %s", name, err)
+				}
+				want, _ := ssh.NewPublicKey(key.Public())
+				if string(signer.PublicKey().Marshal()) != string(want.Marshal()) {
+					t.Fatalf("%s: parsed key does not match", name)
+				}
+			}
+		}
+	}
+
+	data := writePPK(t, 3, ed25519Key, "test key", "")
+	corrupt := strings.Replace(string(data), "Comment: test key", "Comment: other key", 1)
+	if _, _, err := ParsePuTTYKey([]byte(corrupt)); err == nil {
+		t.Fatalf("ParsePuTTYKey accepted a corrupt key file")
+	}
+
+	data = writePPK(t, 3, ed25519Key, "test key", "secret")
+	for _, c := range []struct{ old, new string }{
+		{"Argon2-Passes: 2", "Argon2-Passes: 0"},
+		{"Argon2-Parallelism: 1", "Argon2-Parallelism: 0"},
+		{"Argon2-Memory: 64", "Argon2-Memory: 0"},
+		{"Argon2-Memory: 64", "Argon2-Memory: 4294967295"},
+	} {
+		crafted := strings.Replace(string(data), c.old, c.new, 1)
+		if _, _, err := ParsePuTTYKeyWithPassphrase([]byte(crafted), []byte("secret")); err == nil {
+			t.Fatalf("ParsePuTTYKeyWithPassphrase accepted %s", c.new)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package pageant
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	errProxyLocked   = errors.New("agent: locked")
+	errProxyKey      = errors.New("agent: key not found")
+	errProxyRefused  = errors.New("agent: use of key not confirmed")
+	errProxyUpstream = errors.New("agent: no upstream agent")
+)
+
+// ProxyConfig configures a Proxy.
+type ProxyConfig struct {
+	// Upstreams are the agents whose keys the proxy offers, in order.
+	// Keys are added to the first one.
+	Upstreams []agent.ExtendedAgent
+
+	// Allow hides the keys it returns false for; all keys are offered
+	// if it is nil.
+	Allow func(key *agent.Key) bool
+
+	// Confirm is asked before each signature with a key, which is refused
+	// if it returns false; signing needs no confirmation if it is nil.
+	Confirm func(key *agent.Key) bool
+
+	// HideExpired hides the certificates which are no longer valid.
+	HideExpired bool
+}
+
+// Proxy is an agent offering the keys of its upstream agents, filtered by
+// ProxyConfig.Allow, and signing with them after ProxyConfig.Confirm.
+// Locking a Proxy locks the proxy itself rather than its upstreams.
+// It is safe to use Proxy in multiple concurrent goroutines.
+type Proxy struct {
+	config     ProxyConfig
+	mu         sync.Mutex
+	locked     bool
+	passphrase []byte
+}
+
+// NewProxy returns a Proxy for config.
+func NewProxy(config ProxyConfig) *Proxy {
+	return &Proxy{config: config}
+}
+
+// isLocked tells whether the proxy is locked.
+func (p *Proxy) isLocked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.locked
+}
+
+// list returns the allowed keys of all upstreams, each with the upstream
+// holding it. Upstreams failing to list their keys are skipped.
+func (p *Proxy) list() ([]*agent.Key, []agent.ExtendedAgent, error) {
+	var keys []*agent.Key
+	var owners []agent.ExtendedAgent
+	var lastErr error
+	now := time.Now()
+	failed := 0
+	for _, upstream := range p.config.Upstreams {
+		upstreamKeys, err := upstream.List()
+		if err != nil {
+			lastErr = err
+			failed++
+			continue
+		}
+	next:
+		for _, key := range upstreamKeys {
+			if p.config.Allow != nil && !p.config.Allow(key) {
+				continue
+			}
+			if p.config.HideExpired && expired(parseCertificate(key), now) {
+				continue
+			}
+			for _, k := range keys {
+				if bytes.Equal(k.Blob, key.Blob) {
+					continue next
+				}
+			}
+			keys = append(keys, key)
+			owners = append(owners, upstream)
+		}
+	}
+	if failed > 0 && failed == len(p.config.Upstreams) {
+		return nil, nil, lastErr
+	}
+	return keys, owners, nil
+}
+
+// find returns the allowed key matching pub and the upstream holding it.
+func (p *Proxy) find(pub ssh.PublicKey) (*agent.Key, agent.ExtendedAgent, error) {
+	keys, owners, err := p.list()
+	if err != nil {
+		return nil, nil, err
+	}
+	blob := pub.Marshal()
+	for i, key := range keys {
+		if bytes.Equal(key.Blob, blob) {
+			return key, owners[i], nil
+		}
+	}
+	return nil, nil, errProxyKey
+}
+
+func (p *Proxy) List() ([]*agent.Key, error) {
+	if p.isLocked() {
+		return nil, nil
+	}
+	keys, _, err := p.list()
+	return keys, err
+}
+
+func (p *Proxy) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return p.SignWithFlags(key, data, 0)
+}
+
+func (p *Proxy) SignWithFlags(pub ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if p.isLocked() {
+		return nil, errProxyLocked
+	}
+	key, upstream, err := p.find(pub)
+	if err != nil {
+		return nil, err
+	}
+	if p.config.Confirm != nil && !p.config.Confirm(key) {
+		return nil, errProxyRefused
+	}
+	if flags == 0 {
+		return upstream.Sign(pub, data)
+	}
+	return upstream.SignWithFlags(pub, data, flags)
+}
+
+func (p *Proxy) Add(key agent.AddedKey) error {
+	if p.isLocked() {
+		return errProxyLocked
+	}
+	if len(p.config.Upstreams) == 0 {
+		return errProxyUpstream
+	}
+	return p.config.Upstreams[0].Add(key)
+}
+
+func (p *Proxy) Remove(pub ssh.PublicKey) error {
+	if p.isLocked() {
+		return errProxyLocked
+	}
+	_, upstream, err := p.find(pub)
+	if err != nil {
+		return err
+	}
+	return upstream.Remove(pub)
+}
+
+// RemoveAll removes the allowed keys of all upstreams, leaving the keys
+// hidden by ProxyConfig.Allow alone.
+func (p *Proxy) RemoveAll() error {
+	if p.isLocked() {
+		return errProxyLocked
+	}
+	keys, owners, err := p.list()
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return err
+		}
+		if err := owners[i].Remove(pub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveExpired removes the certificates which are no longer valid from
+// the upstreams, leaving the keys hidden by ProxyConfig.Allow alone, and
+// returns how many were removed.
+func (p *Proxy) RemoveExpired() (int, error) {
+	if p.isLocked() {
+		return 0, errProxyLocked
+	}
+	removed := 0
+	for _, upstream := range p.config.Upstreams {
+		n, err := removeExpired(upstream, p.config.Allow)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (p *Proxy) Lock(passphrase []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.locked {
+		return errProxyLocked
+	}
+	p.locked = true
+	p.passphrase = append([]byte(nil), passphrase...)
+	return nil
+}
+
+func (p *Proxy) Unlock(passphrase []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.locked {
+		return fmt.Errorf("agent: not locked")
+	}
+	if subtle.ConstantTimeCompare(passphrase, p.passphrase) != 1 {
+		return fmt.Errorf("agent: incorrect passphrase")
+	}
+	p.locked = false
+	p.passphrase = nil
+	return nil
+}
+
+func (p *Proxy) Signers() ([]ssh.Signer, error) {
+	return agentSigners(p)
+}
+
+func (p *Proxy) Extension(_ string, _ []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
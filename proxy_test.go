@@ -0,0 +1,62 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestProxy(t *testing.T) {
+	upstreams := []agent.ExtendedAgent{
+		agent.NewKeyring().(agent.ExtendedAgent),
+		agent.NewKeyring().(agent.ExtendedAgent),
+	}
+	for i, comment := range []string{"work", "personal"} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error on GenerateKey: %s", err)
+		}
+		if err := upstreams[i].Add(agent.AddedKey{PrivateKey: priv, Comment: comment}); err != nil {
+			t.Fatalf("error on Add: %s", err)
+		}
+	}
+	confirmed := 0
+	proxy := NewProxy(ProxyConfig{
+		Upstreams: upstreams,
+		Allow:     func(key *agent.Key) bool { return key.Comment == "work" },
+		Confirm:   func(key *agent.Key) bool { confirmed++; return true },
+	})
+
+	keys, err := proxy.List()
+	if err != nil {
+		t.Fatalf("error on Proxy.List: %s", err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "work" {
+		t.Fatalf("Proxy.List returned %v", keys)
+	}
+	signers, err := proxy.Signers()
+	if err != nil {
+		t.Fatalf("error on Proxy.Signers: %s", err)
+	}
+	if _, err := signers[0].Sign(rand.Reader, []byte("data")); err != nil || confirmed != 1 {
+		t.Fatalf("error on Sign: %v, confirmed %d times", err, confirmed)
+	}
+
+	hidden, _ := upstreams[1].List()
+	pub, _ := ssh.ParsePublicKey(hidden[0].Blob)
+	if _, err := proxy.Sign(pub, []byte("data")); err == nil {
+		t.Fatalf("Proxy.Sign used a key hidden by Allow")
+	}
+	if err := proxy.Lock([]byte("secret")); err != nil {
+		t.Fatalf("error on Proxy.Lock: %s", err)
+	}
+	if keys, _ := proxy.List(); len(keys) != 0 {
+		t.Fatalf("locked Proxy listed %d keys", len(keys))
+	}
+	if err := proxy.Unlock([]byte("secret")); err != nil {
+		t.Fatalf("error on Proxy.Unlock: %s", err)
+	}
+}
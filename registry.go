@@ -0,0 +1,138 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// configKey is the registry key of Config under HKLM and HKCU.
+const configKey = `SOFTWARE\trzsz\pageant`
+
+// LoadConfig reads the Config deployed in the registry key
+// SOFTWARE\trzsz\pageant of HKLM and HKCU, with the values Backends,
+// AllowedBackends and ForbiddenBackends as REG_MULTI_SZ or comma separated
+// REG_SZ, AuditLog and AuditKeyFile as REG_SZ or REG_EXPAND_SZ, and
+// RequireOwnAgent as REG_DWORD. Missing keys and values, and values of
+// another type, are left empty. HKCU is ignored if it cannot be read, as
+// the user could not restrict the policy of HKLM with it anyway.
+func LoadConfig() (*Config, error) {
+	machine, err := readConfig(registry.LOCAL_MACHINE)
+	if err != nil {
+		return nil, err
+	}
+	user, err := readConfig(registry.CURRENT_USER)
+	if err != nil {
+		return machine, nil
+	}
+
+	config := *machine
+	if len(user.Backends) > 0 {
+		config.Backends = user.Backends
+	}
+	if len(config.AllowedBackends) == 0 {
+		config.AllowedBackends = user.AllowedBackends
+	} else if len(user.AllowedBackends) > 0 {
+		var both []string
+		for _, backend := range config.AllowedBackends {
+			for _, allowed := range user.AllowedBackends {
+				if allowed == backend {
+					both = append(both, backend)
+					break
+				}
+			}
+		}
+		if len(both) == 0 {
+			// an empty list would allow everything
+			both = []string{""}
+		}
+		config.AllowedBackends = both
+	}
+	config.ForbiddenBackends = append(config.ForbiddenBackends, user.ForbiddenBackends...)
+	config.RequireOwnAgent = config.RequireOwnAgent || user.RequireOwnAgent
+	if config.AuditLog == "" {
+		config.AuditLog = user.AuditLog
+		config.AuditKeyFile = user.AuditKeyFile
+	}
+	return &config, nil
+}
+
+// readConfig reads the Config of one registry root.
+func readConfig(root registry.Key) (*Config, error) {
+	key, err := registry.OpenKey(root, configKey, registry.QUERY_VALUE)
+	if errors.Is(err, registry.ErrNotExist) {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open registry key %s: %s", configKey, err)
+	}
+	defer key.Close()
+
+	config := &Config{}
+	if config.Backends, err = registryStrings(key, "Backends"); err != nil {
+		return nil, err
+	}
+	if config.AllowedBackends, err = registryStrings(key, "AllowedBackends"); err != nil {
+		return nil, err
+	}
+	if config.AuditLog, err = registryString(key, "AuditLog"); err != nil {
+		return nil, err
+	}
+	if config.AuditKeyFile, err = registryString(key, "AuditKeyFile"); err != nil {
+		return nil, err
+	}
+	if config.ForbiddenBackends, err = registryStrings(key, "ForbiddenBackends"); err != nil {
+		return nil, err
+	}
+	requireOwnAgent, _, err := key.GetIntegerValue("RequireOwnAgent")
+	if err != nil && !errors.Is(err, registry.ErrNotExist) && !errors.Is(err, registry.ErrUnexpectedType) {
+		return nil, fmt.Errorf("failed to read registry value RequireOwnAgent: %s", err)
+	}
+	config.RequireOwnAgent = requireOwnAgent != 0
+	return config, nil
+}
+
+// registryStrings reads a list from a REG_MULTI_SZ or comma separated
+// REG_SZ value, or nil for a value of another type.
+func registryStrings(key registry.Key, name string) ([]string, error) {
+	values, _, err := key.GetStringsValue(name)
+	if errors.Is(err, registry.ErrUnexpectedType) {
+		var value string
+		if value, err = registryString(key, name); err != nil || value == "" {
+			return nil, err
+		}
+		values = strings.Split(value, ",")
+	} else if errors.Is(err, registry.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read registry value %s: %s", name, err)
+	}
+	var result []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+// registryString reads a REG_SZ or REG_EXPAND_SZ value, expanding the
+// environment variables of the latter, or "" for a value of another type.
+func registryString(key registry.Key, name string) (string, error) {
+	value, valueType, err := key.GetStringValue(name)
+	if errors.Is(err, registry.ErrNotExist) || errors.Is(err, registry.ErrUnexpectedType) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read registry value %s: %s", name, err)
+	}
+	if valueType == registry.EXPAND_SZ {
+		if value, err = registry.ExpandString(value); err != nil {
+			return "", fmt.Errorf("failed to expand registry value %s: %s", name, err)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,325 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	wmClose   = 0x0010
+	wmDestroy = 0x0002
+)
+
+var (
+	registerClassEx  = user32.NewProc("RegisterClassExW")
+	createWindowEx   = user32.NewProc("CreateWindowExW")
+	defWindowProc    = user32.NewProc("DefWindowProcW")
+	getMessage       = user32.NewProc("GetMessageW")
+	translateMessage = user32.NewProc("TranslateMessage")
+	dispatchMessage  = user32.NewProc("DispatchMessageW")
+	postMessage      = user32.NewProc("PostMessageW")
+	postQuitMessage  = user32.NewProc("PostQuitMessage")
+	openFileMapping  = kernel32.NewProc("OpenFileMappingW")
+
+	serverClass struct {
+		once    sync.Once
+		err     error
+		mu      sync.Mutex
+		windows map[uintptr]*Server
+	}
+)
+
+// Server answers Pageant requests with an agent through a hidden window
+// named Pageant, so that PuTTY and other Pageant clients can use it.
+// The window lives on its own locked OS thread, which only pumps messages;
+// requests are served by worker goroutines.
+type Server struct {
+	agent  agent.Agent
+	window uintptr
+	done   chan struct{}
+}
+
+// NewServer returns a server for a. Start must be called to serve.
+func NewServer(a agent.Agent) *Server {
+	return &Server{agent: a}
+}
+
+// Start creates the Pageant window and serves requests until Stop.
+// It fails if Pageant, or another Server, is already running.
+func (s *Server) Start() error {
+	if s.done != nil {
+		return fmt.Errorf("server already started")
+	}
+	invalidatePageantWindow()
+	if PageantAvailable() {
+		return fmt.Errorf("a Pageant window already exists")
+	}
+	started := make(chan error)
+	s.done = make(chan struct{})
+	go s.loop(started)
+	if err := <-started; err != nil {
+		s.done = nil
+		return err
+	}
+	invalidatePageantWindow()
+	return nil
+}
+
+// Stop destroys the Pageant window and waits for its message loop to exit.
+// Requests being served are allowed to finish.
+func (s *Server) Stop() error {
+	if s.done == nil {
+		return nil
+	}
+	result, _, err := postMessage.Call(s.window, wmClose, 0, 0)
+	if result == 0 {
+		return fmt.Errorf("failed to stop Pageant window: %s", err)
+	}
+	<-s.done
+	s.done = nil
+	invalidatePageantWindow()
+	return nil
+}
+
+// loop runs the message loop of the window on a locked OS thread, as
+// windows are owned by, and receive messages on, the thread creating them.
+func (s *Server) loop(started chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(s.done)
+
+	window, err := createServerWindow()
+	if err != nil {
+		started <- err
+		return
+	}
+	s.window = window
+	serverClass.mu.Lock()
+	serverClass.windows[window] = s
+	serverClass.mu.Unlock()
+	started <- nil
+
+	var m winMsg
+	for {
+		result, _, _ := getMessage.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(result) <= 0 {
+			break
+		}
+		translateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		dispatchMessage.Call(uintptr(unsafe.Pointer(&m)))
+	}
+
+	serverClass.mu.Lock()
+	delete(serverClass.windows, window)
+	serverClass.mu.Unlock()
+}
+
+// createServerWindow registers the Pageant window class once per process
+// and creates a hidden top level window of it, which FindWindow can find.
+func createServerWindow() (uintptr, error) {
+	var instance windows.Handle
+	if err := windows.GetModuleHandleEx(0, nil, &instance); err != nil {
+		return 0, fmt.Errorf("failed to get module handle: %s", err)
+	}
+	serverClass.once.Do(func() {
+		serverClass.windows = make(map[uintptr]*Server)
+		class := wndClassEx{
+			wndProc:   windows.NewCallback(serverWndProc),
+			instance:  instance,
+			className: pageantWindowName,
+		}
+		class.size = uint32(unsafe.Sizeof(class))
+		result, _, err := registerClassEx.Call(uintptr(unsafe.Pointer(&class)))
+		if result == 0 {
+			serverClass.err = fmt.Errorf("failed to register Pageant window class: %s", err)
+		}
+	})
+	if serverClass.err != nil {
+		return 0, serverClass.err
+	}
+	window, _, err := createWindowEx.Call(
+		0,
+		uintptr(unsafe.Pointer(pageantWindowName)),
+		uintptr(unsafe.Pointer(pageantWindowName)),
+		0,
+		0, 0, 0, 0,
+		0,
+		0,
+		uintptr(instance),
+		0,
+	)
+	if window == 0 {
+		return 0, fmt.Errorf("failed to create Pageant window: %s", err)
+	}
+	return window, nil
+}
+
+// serverWndProc is the window procedure of all Server windows.
+func serverWndProc(window, message, wParam, lParam uintptr) uintptr {
+	switch message {
+	case wmCopyData:
+		serverClass.mu.Lock()
+		s := serverClass.windows[window]
+		serverClass.mu.Unlock()
+		if s == nil {
+			return 0
+		}
+		cds := *(**copyData)(unsafe.Pointer(&lParam))
+		if cds.dwData != agentCopydataID || cds.cbData == 0 {
+			return 0
+		}
+		name := toSlice(cds.lpData, int(cds.cbData))
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		mapName := string(name)
+		// SendMessage waits for the answer anyway, but the agent is served
+		// off the locked thread.
+		result := make(chan uintptr)
+		go func() { result <- s.serve(mapName) }()
+		return <-result
+	case wmDestroy:
+		postQuitMessage.Call(0)
+		return 0
+	}
+	result, _, _ := defWindowProc.Call(window, message, wParam, lParam)
+	return result
+}
+
+// serve answers the request in the shared memory named mapName and returns
+// 1, or 0 if the request could not be answered.
+func (s *Server) serve(mapName string) uintptr {
+	mapNameUTF16, err := windows.UTF16PtrFromString(mapName)
+	if err != nil {
+		return 0
+	}
+	sharedFile, _, _ := openFileMapping.Call(windows.FILE_MAP_WRITE|windows.READ_CONTROL, 0, uintptr(unsafe.Pointer(mapNameUTF16)))
+	if sharedFile == 0 {
+		return 0
+	}
+	defer windows.CloseHandle(windows.Handle(sharedFile))
+	if err := verifyMappingOwner(windows.Handle(sharedFile)); err != nil {
+		return 0
+	}
+	sharedMem, err := windows.MapViewOfFile(windows.Handle(sharedFile), windows.FILE_MAP_WRITE, 0, 0, 0)
+	if err != nil {
+		return 0
+	}
+	defer windows.UnmapViewOfFile(sharedMem)
+	// like Pageant 0.75 and later, take messages as long as the shared
+	// memory of the client, up to pageantLargeMsglen
+	var info windows.MemoryBasicInformation
+	if err := windows.VirtualQuery(sharedMem, &info, unsafe.Sizeof(info)); err != nil || info.RegionSize < agentMaxMsglen {
+		return 0
+	}
+	msglen := int(info.RegionSize)
+	if msglen > pageantLargeMsglen {
+		msglen = pageantLargeMsglen
+	}
+
+	size := binary.BigEndian.Uint32(toSlice(sharedMem, 4))
+	if size == 0 || int(size) > msglen-4 {
+		return 0
+	}
+	request := make([]byte, 4+size)
+	copy(request, toSlice(sharedMem, len(request)))
+	response := serveRequest(s.agent, request)
+	if len(response) > msglen {
+		response = []byte{0, 0, 0, 1, agentFailure}
+	}
+	copy(toSlice(sharedMem, len(response)), response)
+	return 1
+}
+
+// agentFailure is SSH_AGENT_FAILURE.
+const agentFailure = 5
+
+// serveRequest answers a framed request with a and returns the framed
+// response. The query extension is always answered, with the extensions of
+// a if it lists them, telling clients that large messages are accepted.
+func serveRequest(a agent.Agent, request []byte) []byte {
+	if bytes.Equal(request[4:], ssh.Marshal(struct {
+		Type      byte
+		Extension string
+	}{agentcExtension, "query"})) {
+		response := []byte{agentSuccess}
+		if extended, ok := a.(agent.ExtendedAgent); ok {
+			if r, err := extended.Extension("query", nil); err == nil && len(r) > 0 && r[0] == agentSuccess {
+				response = r
+			}
+		}
+		framed := make([]byte, 4+len(response))
+		binary.BigEndian.PutUint32(framed, uint32(len(response)))
+		copy(framed[4:], response)
+		return framed
+	}
+	var response bytes.Buffer
+	agent.ServeAgent(a, struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(request), &response})
+	if response.Len() == 0 {
+		return []byte{0, 0, 0, 1, agentFailure}
+	}
+	return response.Bytes()
+}
+
+// verifyMappingOwner checks that the shared memory of a request belongs to
+// the current user, as Pageant does, so that other users cannot use the keys.
+func verifyMappingOwner(sharedFile windows.Handle) error {
+	sd, err := windows.GetSecurityInfo(sharedFile, windows.SE_KERNEL_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return err
+	}
+	if !owner.Equals(user.User.Sid) {
+		return fmt.Errorf("request from another user %s", owner)
+	}
+	return nil
+}
+
+// wndClassEx is equivalent to WNDCLASSEXW.
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   windows.Handle
+	icon       windows.Handle
+	cursor     windows.Handle
+	background windows.Handle
+	menuName   *uint16
+	className  *uint16
+	iconSm     windows.Handle
+}
+
+// winMsg is equivalent to MSG.
+type winMsg struct {
+	window  uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+	private uint32
+}
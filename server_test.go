@@ -0,0 +1,185 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	// listRequest is answered with an identity list, of type 12, and
+	// unknownRequest with a failure, of type 5, telling responses apart.
+	listRequest    = []byte{0, 0, 0, 1, 11}
+	unknownRequest = []byte{0, 0, 0, 1, 200}
+)
+
+// startServer serves a through a Pageant window of this process until the
+// test ends.
+func startServer(t *testing.T, a agent.Agent) {
+	if PageantAvailable() {
+		t.Skip("Pageant is running")
+	}
+	server := NewServer(a)
+	if err := server.Start(); err != nil {
+		t.Fatalf("error on Server.Start: %s", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+}
+
+// dialServer connects to the server of startServer with opts.
+func dialServer(t *testing.T, opts *Options) net.Conn {
+	conn, err := dialPageant(opts)
+	if err != nil {
+		t.Fatalf("error on dialPageant: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// write writes request to conn, failing the test on error.
+func write(t *testing.T, conn net.Conn, request []byte) {
+	if _, err := conn.Write(request); err != nil {
+		t.Fatalf("error on Write: %s", err)
+	}
+}
+
+// readType reads a framed response from conn and returns its type.
+func readType(t *testing.T, conn net.Conn) byte {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("error on reading response: %s", err)
+	}
+	body := make([]byte, int(header[0])<<24|int(header[1])<<16|int(header[2])<<8|int(header[3]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("error on reading response: %s", err)
+	}
+	return body[0]
+}
+
+func TestBackpressureDiscard(t *testing.T) {
+	startServer(t, agent.NewKeyring())
+	conn := dialServer(t, &Options{})
+	write(t, conn, listRequest)
+	write(t, conn, unknownRequest)
+	if typ := readType(t, conn); typ != agentFailure {
+		t.Fatalf("read a response of type %d rather than the last one", typ)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after the last response gave %v rather than EOF", err)
+	}
+}
+
+func TestBackpressureError(t *testing.T) {
+	startServer(t, agent.NewKeyring())
+	conn := dialServer(t, &Options{Backpressure: BackpressureError})
+	write(t, conn, listRequest)
+	if _, err := conn.Write(unknownRequest); err != ErrResponsePending {
+		t.Fatalf("Write over an unread response gave %v", err)
+	}
+	if typ := readType(t, conn); typ != 12 {
+		t.Fatalf("read a response of type %d rather than the identity list", typ)
+	}
+	write(t, conn, unknownRequest)
+}
+
+func TestBackpressureBlock(t *testing.T) {
+	startServer(t, agent.NewKeyring())
+	conn := dialServer(t, &Options{Backpressure: BackpressureBlock})
+	write(t, conn, listRequest)
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(unknownRequest)
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("Write over an unread response did not block: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if typ := readType(t, conn); typ != 12 {
+		t.Fatalf("read a response of type %d rather than the identity list", typ)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("error on the blocked Write: %s", err)
+	}
+	if typ := readType(t, conn); typ != agentFailure {
+		t.Fatalf("read a response of type %d rather than the failure", typ)
+	}
+
+	// Close wakes a blocked writer up
+	write(t, conn, listRequest)
+	go func() {
+		_, err := conn.Write(unknownRequest)
+		written <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-written:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("the Write blocked by Close gave %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close did not unblock the blocked Write")
+	}
+}
+
+func TestResponseQueue(t *testing.T) {
+	startServer(t, agent.NewKeyring())
+	conn := dialServer(t, &Options{ResponseQueue: 2, Backpressure: BackpressureError})
+	requests := [][]byte{listRequest, unknownRequest, listRequest}
+	for _, request := range requests {
+		write(t, conn, request)
+	}
+	if _, err := conn.Write(unknownRequest); err != ErrResponsePending {
+		t.Fatalf("Write over a full queue gave %v", err)
+	}
+	for i, want := range []byte{12, agentFailure, 12} {
+		if typ := readType(t, conn); typ != want {
+			t.Fatalf("response %d is of type %d rather than %d", i, typ, want)
+		}
+	}
+
+	// a full queue with BackpressureDiscard drops every unread response
+	conn = dialServer(t, &Options{ResponseQueue: 1})
+	for _, request := range requests {
+		write(t, conn, request)
+	}
+	if typ := readType(t, conn); typ != 12 {
+		t.Fatalf("read a response of type %d rather than the last one", typ)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after the last response gave %v rather than EOF", err)
+	}
+}
+
+func TestServerCapabilities(t *testing.T) {
+	for _, query := range []bool{false, true} {
+		t.Run(map[bool]string{false: "keyring", true: "query"}[query], func(t *testing.T) {
+			var keyring agent.Agent = agent.NewKeyring()
+			if query {
+				keyring = queryAgent{keyring.(agent.ExtendedAgent)}
+			}
+			startServer(t, keyring)
+			caps, err := Capabilities(dialServer(t, &Options{}))
+			if err != nil {
+				t.Fatalf("error on Capabilities: %s", err)
+			}
+			// the window answers query, passing on the extensions of the
+			// agent and its support of locks and constraints
+			if !caps.Query || len(caps.Extensions) != map[bool]int{false: 0, true: 2}[query] {
+				t.Fatalf("Capabilities found %+v", caps)
+			}
+			if caps.Lock == query || caps.Constraints == query {
+				t.Fatalf("Capabilities found %+v", caps)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package pageant
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSigners returns signers for the keys of a that sign through a,
+// so that agents wrapping another agent see the signatures they make.
+func agentSigners(a agent.ExtendedAgent) ([]ssh.Signer, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, key := range keys {
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, &agentSigner{agent: a, pub: pub})
+	}
+	return signers, nil
+}
+
+// agentSigner is an ssh.AlgorithmSigner for a key held by an agent.
+type agentSigner struct {
+	agent agent.ExtendedAgent
+	pub   ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.agent.Sign(s.pub, data)
+}
+
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case "", s.pub.Type(), ssh.KeyAlgoRSA, ssh.CertAlgoRSAv01:
+		return s.agent.Sign(s.pub, data)
+	case ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01:
+		flags = agent.SignatureFlagRsaSha512
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %s", algorithm)
+	}
+	return s.agent.SignWithFlags(s.pub, data, flags)
+}
@@ -0,0 +1,57 @@
+//go:build !windows && !plan9 && !wasip1
+// +build !windows,!plan9,!wasip1
+
+package pageant
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenUnix listens on a unix socket at path that only the current user
+// may connect to. The socket is bound in a private directory next to path
+// and made 0600 there, then linked at path, so that it is never reachable
+// with the permissions of the umask. Like net.Listen, it fails if path
+// exists, and closing the listener removes path.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".pageant")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(private, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Link(private, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{listener, &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// unixListener is a listener linked at addr rather than where it was bound.
+type unixListener struct {
+	*net.UnixListener
+	addr *net.UnixAddr
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close stops listening and removes the socket at addr.
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if err == nil {
+		os.Remove(l.addr.Name)
+	}
+	return err
+}
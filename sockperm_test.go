@@ -0,0 +1,38 @@
+//go:build !windows && !plan9 && !wasip1
+// +build !windows,!plan9,!wasip1
+
+package pageant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	info, err := os.Stat(socket)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("socket is %v, %v", info.Mode(), err)
+	}
+	if listener.Addr().String() != socket {
+		t.Fatalf("listener is at %s rather than %s", listener.Addr(), socket)
+	}
+	if _, err := Listen("unix:" + socket); err == nil {
+		t.Fatalf("Listen on a socket in use succeeded")
+	}
+
+	// the private directory is gone, and closing removes the socket
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("%d files left next to the socket", len(entries))
+	}
+	listener.Close()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket left after Close: %v", err)
+	}
+}
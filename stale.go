@@ -0,0 +1,15 @@
+//go:build !plan9
+// +build !plan9
+
+package pageant
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isStaleSocket tells whether err is the refusal of a unix socket no agent
+// listens on anymore.
+func isStaleSocket(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
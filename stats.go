@@ -0,0 +1,202 @@
+package pageant
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// StatsExtension is the agent extension answered by a StatsAgent with the
+// JSON of its Stats, so that the usage of the keys of a running agent can be
+// read by any client, such as pageant-keys stats.
+const StatsExtension = "stats@trzsz.github.io"
+
+// KeyStats is the usage of one key of a StatsAgent.
+type KeyStats struct {
+	Fingerprint string    `json:"fingerprint"`
+	Comment     string    `json:"comment,omitempty"`
+	Signs       uint64    `json:"signs"`
+	Failures    uint64    `json:"failures"`
+	LastUsed    time.Time `json:"last_used"`
+	// LastPeer is the client of the last signature, if known, such as the
+	// process of a unix socket connection on Linux.
+	LastPeer string `json:"last_peer,omitempty"`
+}
+
+// StatsAgent forwards to another agent and counts the signatures made with
+// each key, to find out which keys are actually used.
+// It is safe to use StatsAgent in multiple concurrent goroutines.
+type StatsAgent struct {
+	agent    agent.ExtendedAgent
+	mu       sync.Mutex
+	stats    map[string]*KeyStats
+	comments map[string]string
+}
+
+// NewStatsAgent returns a StatsAgent counting the signatures of a.
+// Serve records the peer of each connection to a StatsAgent where known.
+func NewStatsAgent(a agent.ExtendedAgent) *StatsAgent {
+	return &StatsAgent{
+		agent:    a,
+		stats:    make(map[string]*KeyStats),
+		comments: make(map[string]string),
+	}
+}
+
+// WithPeer returns an agent recording peer as the client of its signatures.
+func (a *StatsAgent) WithPeer(peer string) agent.ExtendedAgent {
+	return statsPeer{StatsAgent: a, peer: peer}
+}
+
+// Stats returns the usage of the keys signed with, most used first.
+func (a *StatsAgent) Stats() []KeyStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := make([]KeyStats, 0, len(a.stats))
+	for _, s := range a.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Signs != stats[j].Signs {
+			return stats[i].Signs > stats[j].Signs
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	return stats
+}
+
+// record counts a signature with key by peer, failed if err is not nil.
+// Failures are only counted for keys listed or signed with before.
+func (a *StatsAgent) record(key ssh.PublicKey, peer string, err error) {
+	fingerprint := ssh.FingerprintSHA256(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.stats[fingerprint]
+	if _, held := a.comments[fingerprint]; s == nil && err != nil && !held {
+		// clients must not grow the stats with keys the agent does not hold
+		return
+	}
+	if s == nil {
+		s = &KeyStats{Fingerprint: fingerprint, Comment: a.comments[fingerprint]}
+		a.stats[fingerprint] = s
+	}
+	if err != nil {
+		s.Failures++
+		return
+	}
+	s.Signs++
+	s.LastUsed = time.Now().UTC()
+	if peer != "" {
+		s.LastPeer = peer
+	}
+}
+
+func (a *StatsAgent) List() ([]*agent.Key, error) {
+	keys, err := a.agent.List()
+	if err == nil {
+		// keep the comments of the keys, naming them in Stats
+		a.mu.Lock()
+		a.comments = make(map[string]string, len(keys))
+		for _, key := range keys {
+			if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+				fingerprint := ssh.FingerprintSHA256(pub)
+				a.comments[fingerprint] = key.Comment
+				if s := a.stats[fingerprint]; s != nil {
+					s.Comment = key.Comment
+				}
+			}
+		}
+		a.mu.Unlock()
+	}
+	return keys, err
+}
+
+func (a *StatsAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.WithPeer("").Sign(key, data)
+}
+
+func (a *StatsAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	return a.WithPeer("").SignWithFlags(key, data, flags)
+}
+
+func (a *StatsAgent) Add(key agent.AddedKey) error {
+	return a.agent.Add(key)
+}
+
+func (a *StatsAgent) Remove(key ssh.PublicKey) error {
+	return a.agent.Remove(key)
+}
+
+func (a *StatsAgent) RemoveAll() error {
+	return a.agent.RemoveAll()
+}
+
+func (a *StatsAgent) Lock(passphrase []byte) error {
+	return a.agent.Lock(passphrase)
+}
+
+func (a *StatsAgent) Unlock(passphrase []byte) error {
+	return a.agent.Unlock(passphrase)
+}
+
+func (a *StatsAgent) Signers() ([]ssh.Signer, error) {
+	return agentSigners(a)
+}
+
+func (a *StatsAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	if extensionType != StatsExtension {
+		return a.agent.Extension(extensionType, contents)
+	}
+	data, err := json.Marshal(a.Stats())
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{agentSuccess}, ssh.Marshal(struct{ Stats string }{string(data)})...), nil
+}
+
+// statsPeer is a StatsAgent recording the signatures of one peer.
+type statsPeer struct {
+	*StatsAgent
+	peer string
+}
+
+func (a statsPeer) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	sig, err := a.agent.Sign(key, data)
+	a.record(key, a.peer, err)
+	return sig, err
+}
+
+func (a statsPeer) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	sig, err := a.agent.SignWithFlags(key, data, flags)
+	a.record(key, a.peer, err)
+	return sig, err
+}
+
+func (a statsPeer) Signers() ([]ssh.Signer, error) {
+	return agentSigners(a)
+}
+
+// AgentStats asks the agent of client for the Stats of its StatsAgent.
+func AgentStats(client agent.ExtendedAgent) ([]KeyStats, error) {
+	response, err := client.Extension(StatsExtension, nil)
+	if err != nil {
+		return nil, fmt.Errorf("agent does not report key usage: %s", err)
+	}
+	var reply struct{ Stats string }
+	if len(response) == 0 || response[0] != agentSuccess {
+		return nil, fmt.Errorf("agent does not report key usage")
+	}
+	if err := ssh.Unmarshal(response[1:], &reply); err != nil {
+		return nil, fmt.Errorf("invalid key usage reply: %s", err)
+	}
+	var stats []KeyStats
+	if err := json.Unmarshal([]byte(reply.Stats), &stats); err != nil {
+		return nil, fmt.Errorf("invalid key usage reply: %s", err)
+	}
+	return stats, nil
+}
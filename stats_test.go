@@ -0,0 +1,86 @@
+package pageant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestStatsAgent(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on GenerateKey: %s", err)
+	}
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "used"}); err != nil {
+		t.Fatalf("error on Add: %s", err)
+	}
+	stats := NewStatsAgent(keyring)
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen("unix:" + socket)
+	if err != nil {
+		t.Fatalf("error on Listen: %s", err)
+	}
+	defer listener.Close()
+	go Serve(stats, listener)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("error on Dial: %s", err)
+	}
+	defer conn.Close()
+	client := agent.NewClient(conn)
+
+	signers, err := client.Signers()
+	if err != nil || len(signers) != 1 {
+		t.Fatalf("error on Signers: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := signers[0].Sign(rand.Reader, []byte("data")); err != nil {
+			t.Fatalf("error on Sign: %s", err)
+		}
+	}
+	if err := client.Lock([]byte("passphrase")); err != nil {
+		t.Fatalf("error on Lock: %s", err)
+	}
+	if _, err := signers[0].Sign(rand.Reader, []byte("data")); err == nil {
+		t.Fatalf("Sign succeeded with a locked agent")
+	}
+	if err := client.Unlock([]byte("passphrase")); err != nil {
+		t.Fatalf("error on Unlock: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, other, _ := ed25519.GenerateKey(rand.Reader)
+		otherSigner, _ := ssh.NewSignerFromKey(other)
+		if _, err := client.Sign(otherSigner.PublicKey(), []byte("data")); err == nil {
+			t.Fatalf("Sign succeeded with a missing key")
+		}
+	}
+
+	got, err := AgentStats(client)
+	if err != nil {
+		t.Fatalf("error on AgentStats: %s", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("AgentStats returned %d keys rather than the one held", len(got))
+	}
+	used := got[0]
+	if used.Fingerprint != ssh.FingerprintSHA256(signers[0].PublicKey()) || used.Comment != "used" ||
+		used.Signs != 2 || used.Failures != 1 || used.LastUsed.IsZero() {
+		t.Fatalf("AgentStats returned %+v for the used key", used)
+	}
+	if runtime.GOOS == "linux" && !strings.HasPrefix(used.LastPeer, "pid ") {
+		t.Fatalf("AgentStats returned peer %q", used.LastPeer)
+	}
+
+	if _, err := AgentStats(keyring); err == nil {
+		t.Fatalf("AgentStats succeeded without a StatsAgent")
+	}
+}
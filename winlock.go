@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package pageant
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the byte range locked by lockFile, past any content, as
+// the locks of Windows also refuse reading the range to other processes.
+var lockRange = windows.Overlapped{Offset: ^uint32(0), OffsetHigh: ^uint32(0)}
+
+// lockFile waits for an exclusive lock on file, held against other
+// processes until unlockFile.
+func lockFile(file *os.File) error {
+	overlapped := lockRange
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &overlapped)
+}
+
+// unlockFile releases the lock of lockFile.
+func unlockFile(file *os.File) error {
+	overlapped := lockRange
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &overlapped)
+}